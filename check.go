@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CheckState is a monitoring plugin status; its value is the exit code
+// Nagios and Icinga expect.
+type CheckState int
+
+const (
+	StateOK CheckState = iota
+	StateWarning
+	StateCritical
+	StateUnknown
+)
+
+func (s CheckState) String() string {
+	switch s {
+	case StateOK:
+		return "OK"
+	case StateWarning:
+		return "WARNING"
+	case StateCritical:
+		return "CRITICAL"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// Threshold is an upper bound on the average RTT and on the loss percentage.
+type Threshold struct {
+	RTT  time.Duration
+	Loss float64
+}
+
+// parseThreshold parses RTT,LOSS% thresholds such as "100ms,20%".
+func parseThreshold(s string) (Threshold, error) {
+	rtt, loss, ok := strings.Cut(s, ",")
+	if !ok {
+		return Threshold{}, fmt.Errorf("threshold %q: expected RTT,LOSS%%", s)
+	}
+	var (
+		t   Threshold
+		err error
+	)
+	if t.RTT, err = time.ParseDuration(rtt); err != nil {
+		return Threshold{}, fmt.Errorf("threshold %q: %w", s, err)
+	}
+	if t.Loss, err = strconv.ParseFloat(strings.TrimSuffix(loss, "%"), 64); err != nil {
+		return Threshold{}, fmt.Errorf("threshold %q: %w", s, err)
+	}
+	return t, nil
+}
+
+// evaluate returns the state of a target from its statistics. Losing every
+// probe is always critical.
+func evaluate(s *Stats, warn, crit Threshold) CheckState {
+	switch {
+	case s.Sent == 0:
+		return StateUnknown
+	case s.Received == 0, s.Avg() >= crit.RTT, s.Loss() >= crit.Loss:
+		return StateCritical
+	case s.Avg() >= warn.RTT, s.Loss() >= warn.Loss:
+		return StateWarning
+	default:
+		return StateOK
+	}
+}
+
+// unknown prints the status line of a check that could not run because of
+// err.
+func unknown(w io.Writer, err error) CheckState {
+	fmt.Fprintf(w, "PING %s - %v\n", StateUnknown, err)
+	return StateUnknown
+}
+
+// checkReport builds the single status line of a monitoring plugin, with
+// perfdata after the pipe, and returns the worst state of all targets.
+func checkReport(targets []Target, stats []*Stats, warn, crit Threshold) (string, CheckState) {
+	state := StateOK
+	var msgs, perf []string
+	for i, t := range targets {
+		s := stats[i]
+		state = max(state, evaluate(s, warn, crit))
+
+		msg := fmt.Sprintf("%s loss %.0f%%", t, s.Loss())
+		if s.Received > 0 {
+			msg = fmt.Sprintf("%s rtt %.3fms, loss %.0f%%", t, ms(s.Avg()), s.Loss())
+		}
+		msgs = append(msgs, msg)
+
+		rttLabel, lossLabel := "rtt", "loss"
+		if len(targets) > 1 {
+			rttLabel, lossLabel = fmt.Sprintf("'%s rtt'", t), fmt.Sprintf("'%s loss'", t)
+		}
+		rtt := "U"
+		if s.Received > 0 {
+			rtt = fmt.Sprintf("%.3fms", ms(s.Avg()))
+		}
+		perf = append(perf,
+			fmt.Sprintf("%s=%s;%.3f;%.3f;0;", rttLabel, rtt, ms(warn.RTT), ms(crit.RTT)),
+			fmt.Sprintf("%s=%.0f%%;%g;%g;0;100", lossLabel, s.Loss(), warn.Loss, crit.Loss))
+	}
+	return fmt.Sprintf("PING %s - %s | %s", state, strings.Join(msgs, ", "), strings.Join(perf, " ")), state
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseThreshold(t *testing.T) {
+	th, err := parseThreshold("50ms,20%")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if th.RTT != 50*time.Millisecond || th.Loss != 20 {
+		t.Errorf("unexpected threshold %+v", th)
+	}
+	for _, s := range []string{"50ms", "50,20%", "50ms,many"} {
+		if _, err := parseThreshold(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestCheckReport(t *testing.T) {
+	warn := Threshold{RTT: 50 * time.Millisecond, Loss: 20}
+	crit := Threshold{RTT: 100 * time.Millisecond, Loss: 60}
+	statsOf := func(rtt time.Duration, lost int) *Stats {
+		s := new(Stats)
+		for range 4 - lost {
+			s.Add(rtt, nil)
+		}
+		for range lost {
+			s.Add(0, errors.New("lost"))
+		}
+		return s
+	}
+
+	tests := []struct {
+		stats    *Stats
+		expected CheckState
+	}{
+		{statsOf(12*time.Millisecond, 0), StateOK},
+		{statsOf(60*time.Millisecond, 0), StateWarning},
+		{statsOf(12*time.Millisecond, 1), StateWarning},
+		{statsOf(150*time.Millisecond, 0), StateCritical},
+		{statsOf(12*time.Millisecond, 3), StateCritical},
+		{statsOf(0, 4), StateCritical},
+		{new(Stats), StateUnknown},
+	}
+	for i, c := range tests {
+		if actual := evaluate(c.stats, warn, crit); actual != c.expected {
+			t.Errorf("%d: expected %v; actual %v", i, c.expected, actual)
+		}
+	}
+
+	line, state := checkReport([]Target{{Name: "db:5432"}}, []*Stats{statsOf(12*time.Millisecond, 0)}, warn, crit)
+	expected := "PING OK - db:5432 rtt 12.000ms, loss 0% | rtt=12.000ms;50.000;100.000;0; loss=0%;20;60;0;100"
+	if state != StateOK || line != expected {
+		t.Errorf("expected %q; actual %q (%v)", expected, line, state)
+	}
+
+	_, state = checkReport([]Target{{Name: "a:80"}, {Name: "b:80"}},
+		[]*Stats{statsOf(12*time.Millisecond, 0), statsOf(0, 4)}, warn, crit)
+	if state != StateCritical {
+		t.Errorf("expected the worst state to win; actual %v", state)
+	}
+}
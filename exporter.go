@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/prometheus"
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsNamespace prefixes the names of the metrics the exporter exports.
+const metricsNamespace = "ping"
+
+// probeMetrics holds the per-target metrics exported by the ping command
+// when it runs as a daemon. Every metric is labeled with the probed target.
+type probeMetrics struct {
+	Up                  metrics.Gauge
+	RTT                 metrics.Histogram
+	ConsecutiveFailures metrics.Gauge
+}
+
+// newProbeMetrics creates the probe metrics and registers them with reg.
+func newProbeMetrics(reg prom.Registerer) *probeMetrics {
+	up := prom.NewGaugeVec(prom.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "up",
+		Help:      "Whether the last probe of the target succeeded",
+	}, []string{"target"})
+	rtt := prom.NewHistogramVec(prom.HistogramOpts{
+		Namespace: metricsNamespace,
+		Buckets: []float64{
+			0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025,
+			0.05, 0.1, 0.25, 0.5, 1, 2.5, 5,
+		},
+		Name: "rtt_seconds",
+		Help: "Round trip time of successful probes",
+	}, []string{"target"})
+	failures := prom.NewGaugeVec(prom.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "consecutive_failures",
+		Help:      "Number of failed probes since the last success",
+	}, []string{"target"})
+	reg.MustRegister(up, rtt, failures)
+
+	return &probeMetrics{
+		Up:                  prometheus.NewGauge(up),
+		RTT:                 prometheus.NewHistogram(rtt),
+		ConsecutiveFailures: prometheus.NewGauge(failures),
+	}
+}
+
+// metricsReporter exports probe results instead of printing them.
+type metricsReporter struct {
+	m        *probeMetrics
+	failures map[string]int
+}
+
+func newMetricsReporter(m *probeMetrics) *metricsReporter {
+	return &metricsReporter{m: m, failures: make(map[string]int)}
+}
+
+func (m *metricsReporter) Probe(r Result) {
+	if r.Err != nil {
+		m.failures[r.Target]++
+		m.m.Up.With("target", r.Target).Set(0)
+	} else {
+		m.failures[r.Target] = 0
+		m.m.Up.With("target", r.Target).Set(1)
+		m.m.RTT.With("target", r.Target).Observe(r.RTT.Seconds())
+	}
+	m.m.ConsecutiveFailures.With("target", r.Target).Set(float64(m.failures[r.Target]))
+}
+
+func (m *metricsReporter) Summary(string, *Stats, time.Duration) {}
+
+// probeHandler probes the target query parameter on demand and answers with
+// metrics about that single probe, like the Prometheus blackbox exporter.
+func probeHandler(p Prober) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := r.URL.Query().Get("target")
+		if target == "" {
+			http.Error(w, "target parameter is missing", http.StatusBadRequest)
+			return
+		}
+
+		reg := prom.NewRegistry()
+		success := prom.NewGauge(prom.GaugeOpts{
+			Name: "probe_success",
+			Help: "Whether the probe succeeded",
+		})
+		duration := prom.NewGauge(prom.GaugeOpts{
+			Name: "probe_duration_seconds",
+			Help: "How long the probe took",
+		})
+		reg.MustRegister(success, duration)
+
+		res := p.Probe(r.Context(), Target{Name: target})
+		duration.Set(res.RTT.Seconds())
+		if res.Err == nil {
+			success.Set(1)
+		} else {
+			log.Printf("probe %s: %v", target, res.Err)
+		}
+		promhttp.HandlerFor(reg, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	})
+}
+
+// serve exposes /metrics and /probe on addr and keeps probing targets every
+// interval until ctx is done.
+func serve(ctx context.Context, addr string, targets []Target, interval time.Duration, workers int, p Prober) error {
+	// A registry of our own keeps whatever else registers globally out of
+	// the exporter's output.
+	reg := prom.NewRegistry()
+	reg.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	reporter := newMetricsReporter(newProbeMetrics(reg))
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	mux.Handle("/probe", probeHandler(p))
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		IdleTimeout:       time.Minute,
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.Serve(l)
+	}()
+	fmt.Printf("Exporter is listening on %v ...\n", l.Addr())
+
+	if len(targets) > 0 {
+		go probeLoop(ctx, targets, 0, interval, workers, p, func(_ int, r Result) { reporter.Probe(r) })
+	}
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
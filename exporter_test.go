@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetricsReporter(t *testing.T) {
+	reg := prom.NewRegistry()
+	rep := newMetricsReporter(newProbeMetrics(reg))
+
+	rep.Probe(Result{Target: "a:80", RTT: 5 * time.Millisecond})
+	rep.Probe(Result{Target: "b:80", Err: errors.New("refused")})
+	rep.Probe(Result{Target: "b:80", Err: errors.New("refused")})
+
+	expected := `
+# HELP ping_consecutive_failures Number of failed probes since the last success
+# TYPE ping_consecutive_failures gauge
+ping_consecutive_failures{target="a:80"} 0
+ping_consecutive_failures{target="b:80"} 2
+# HELP ping_up Whether the last probe of the target succeeded
+# TYPE ping_up gauge
+ping_up{target="a:80"} 1
+ping_up{target="b:80"} 0
+`
+	err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"ping_up", "ping_consecutive_failures")
+	if err != nil {
+		t.Error(err)
+	}
+	if n := testutil.CollectAndCount(reg, "ping_rtt_seconds"); n != 1 {
+		t.Errorf("expected one rtt series; actual %d", n)
+	}
+}
+
+func TestProbeHandler(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	s := httptest.NewServer(probeHandler(&tcpProber{timeout: time.Second}))
+	defer s.Close()
+
+	tests := []struct {
+		query    string
+		status   int
+		contains string
+	}{
+		{"?target=" + l.Addr().String(), http.StatusOK, "probe_success 1"},
+		{"?target=127.0.0.1:1", http.StatusOK, "probe_success 0"},
+		{"", http.StatusBadRequest, "target parameter is missing"},
+	}
+	for i, c := range tests {
+		resp, err := http.Get(s.URL + "/probe" + c.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != c.status {
+			t.Errorf("%d: expected status %d; actual %d", i, c.status, resp.StatusCode)
+		}
+		if !strings.Contains(string(b), c.contains) {
+			t.Errorf("%d: expected %q in %q", i, c.contains, b)
+		}
+	}
+}
@@ -0,0 +1,370 @@
+package heartbeat
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultMaxMissed is used when Config.MaxMissed is not positive.
+const DefaultMaxMissed = 3
+
+// ErrDead is returned by Read and passed to Config.OnDead when nothing was
+// received from the peer for MaxMissed intervals.
+var ErrDead = errors.New("heartbeat: peer missed too many beats")
+
+const (
+	kindData uint8 = iota + 1
+	kindPing
+	kindPong
+
+	// maxFrame is the largest frame body; Write splits bigger buffers.
+	maxFrame = 64 << 10
+	// frameHeader is the size of the kind and length ahead of a body.
+	frameHeader = 5
+)
+
+// Config configures a Conn.
+type Config struct {
+	// Interval between pings. DefaultInterval if not positive.
+	Interval time.Duration
+	// MaxMissed is how many intervals may pass without any inbound traffic
+	// before the peer is considered dead. DefaultMaxMissed if not positive.
+	MaxMissed int
+	// OnDead, if set, is called once with the reason the connection died,
+	// after the connection has been closed.
+	OnDead func(err error)
+}
+
+// Stats describes the round trips of the pings sent on a Conn.
+type Stats struct {
+	Sent     int
+	Received int
+	Last     time.Duration
+	Min      time.Duration
+	Max      time.Duration
+	Avg      time.Duration
+}
+
+// Conn is a net.Conn that exchanges heartbeats with its peer alongside the
+// application data. Both ends of the connection must be wrapped in a Conn,
+// since data and heartbeats are framed on the wire.
+//
+// Any frame received from the peer, data or heartbeat, extends the read
+// deadline of the underlying connection. When it expires the peer is
+// considered dead: the connection is closed, OnDead is called and Read
+// returns ErrDead.
+type Conn struct {
+	net.Conn
+
+	cfg      Config
+	interval atomic.Int64
+	reset    chan time.Duration
+	cancel   context.CancelFunc
+	// pongs hands pong bodies from the read loop to the pong writer, so a
+	// blocked write never stalls reading.
+	pongs chan []byte
+
+	// app is handed to the application's Read calls, feed is where the read
+	// loop writes the data frames. net.Pipe gives us read deadlines.
+	app, feed net.Conn
+
+	wmu sync.Mutex
+	// dmu guards the application's write deadline, which is set aside
+	// while a heartbeat is written so it cannot fail the heartbeat.
+	dmu     sync.Mutex
+	wdl     time.Time
+	beating bool
+
+	smu      sync.Mutex
+	stats    Stats
+	rttTotal time.Duration
+
+	closeOnce sync.Once
+	emu       sync.Mutex
+	err       error
+}
+
+// New wraps conn and starts exchanging heartbeats on it.
+func New(conn net.Conn, cfg Config) *Conn {
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultInterval
+	}
+	if cfg.MaxMissed <= 0 {
+		cfg.MaxMissed = DefaultMaxMissed
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &Conn{
+		Conn:   conn,
+		cfg:    cfg,
+		reset:  make(chan time.Duration, 1),
+		cancel: cancel,
+		pongs:  make(chan []byte, 4),
+	}
+	c.app, c.feed = net.Pipe()
+	c.interval.Store(int64(cfg.Interval))
+	c.reset <- cfg.Interval
+
+	go c.readLoop()
+	go c.pongLoop(ctx)
+	go func() {
+		err := beat(ctx, c.reset, c.ping)
+		if err != nil && ctx.Err() == nil {
+			c.fail(err)
+		}
+	}()
+	return c
+}
+
+// SetInterval changes the ping interval at runtime. The missed-beat window
+// follows the new interval from the next inbound frame on.
+func (c *Conn) SetInterval(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	c.interval.Store(int64(d))
+	// Replace a pending value rather than block on a busy beat loop.
+	select {
+	case <-c.reset:
+	default:
+	}
+	c.reset <- d
+}
+
+// Stats returns the round trip statistics of the pings sent so far.
+func (c *Conn) Stats() Stats {
+	c.smu.Lock()
+	defer c.smu.Unlock()
+	return c.stats
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	n, err := c.app.Read(b)
+	if err != nil {
+		c.emu.Lock()
+		if c.err != nil {
+			err = c.err
+		}
+		c.emu.Unlock()
+	}
+	return n, err
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	var n int
+	for len(b) > 0 {
+		chunk := b[:min(len(b), maxFrame)]
+		written, err := c.writeFrame(kindData, chunk)
+		n += max(written-frameHeader, 0)
+		if err != nil {
+			if written > 0 {
+				// Whatever follows a partial frame would be read as the
+				// rest of its body.
+				c.fail(err)
+			}
+			c.emu.Lock()
+			if c.err != nil {
+				err = c.err
+			}
+			c.emu.Unlock()
+			return n, err
+		}
+		b = b[len(chunk):]
+	}
+	return n, nil
+}
+
+// Close stops the heartbeats and closes the underlying connection.
+func (c *Conn) Close() error {
+	err := net.ErrClosed
+	c.closeOnce.Do(func() {
+		c.cancel()
+		err = c.Conn.Close()
+		_ = c.feed.Close()
+		_ = c.app.Close()
+	})
+	return err
+}
+
+// SetDeadline sets the deadline of the application's reads and writes. The
+// read deadline of the underlying connection is owned by the heartbeat.
+func (c *Conn) SetDeadline(t time.Time) error {
+	if err := c.app.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+// SetWriteDeadline sets the deadline of the application's writes.
+// Heartbeats are written without it.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.dmu.Lock()
+	defer c.dmu.Unlock()
+	c.wdl = t
+	if c.beating {
+		// Applied once the heartbeat is written.
+		return nil
+	}
+	return c.Conn.SetWriteDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.app.SetReadDeadline(t)
+}
+
+func (c *Conn) ping() error {
+	var body [8]byte
+	binary.BigEndian.PutUint64(body[:], uint64(time.Now().UnixNano()))
+	c.smu.Lock()
+	c.stats.Sent++
+	c.smu.Unlock()
+	_, err := c.writeFrame(kindPing, body[:])
+	return err
+}
+
+func (c *Conn) pong(body []byte) {
+	if len(body) != 8 {
+		return
+	}
+	rtt := time.Since(time.Unix(0, int64(binary.BigEndian.Uint64(body))))
+	c.smu.Lock()
+	defer c.smu.Unlock()
+	s := &c.stats
+	s.Received++
+	s.Last = rtt
+	if s.Received == 1 || rtt < s.Min {
+		s.Min = rtt
+	}
+	s.Max = max(s.Max, rtt)
+	c.rttTotal += rtt
+	s.Avg = c.rttTotal / time.Duration(s.Received)
+}
+
+func (c *Conn) readLoop() {
+	r := bufio.NewReader(c.Conn)
+	for {
+		window := time.Duration(c.interval.Load()) * time.Duration(c.cfg.MaxMissed)
+		if err := c.Conn.SetReadDeadline(time.Now().Add(window)); err != nil {
+			c.fail(err)
+			return
+		}
+		kind, body, err := readFrame(r)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			// Only this deadline means the peer is gone.
+			err = fmt.Errorf("%w: %w", ErrDead, err)
+		}
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		switch kind {
+		case kindData:
+			if _, err := c.feed.Write(body); err != nil {
+				// The application closed the connection.
+				return
+			}
+		case kindPing:
+			select {
+			case c.pongs <- body:
+			default:
+				// Too many unanswered pings; any pong we do send keeps the
+				// peer's deadline fresh just as well.
+			}
+		case kindPong:
+			c.pong(body)
+		}
+	}
+}
+
+func (c *Conn) pongLoop(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case body := <-c.pongs:
+			if _, err := c.writeFrame(kindPong, body); err != nil {
+				c.fail(err)
+				return
+			}
+		}
+	}
+}
+
+// fail closes the connection because of err. Missed beats, reported by the
+// read loop as ErrDead, trigger OnDead; a clean EOF from the peer is passed
+// through.
+func (c *Conn) fail(err error) {
+	dead := errors.Is(err, ErrDead)
+	c.emu.Lock()
+	closedByUs := errors.Is(err, net.ErrClosed) && c.err == nil
+	if c.err == nil && !errors.Is(err, io.EOF) && !closedByUs {
+		c.err = err
+	}
+	c.emu.Unlock()
+	_ = c.Close()
+	if dead && c.cfg.OnDead != nil {
+		c.cfg.OnDead(err)
+	}
+}
+
+// writeFrame writes a frame of kind and returns how many of its bytes,
+// header included, were written.
+func (c *Conn) writeFrame(kind uint8, body []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if kind != kindData {
+		// A stuck peer is caught by the read deadline, so heartbeats need
+		// no write deadline of their own.
+		c.setBeating(true)
+		defer c.setBeating(false)
+	}
+	return writeFrame(c.Conn, kind, body)
+}
+
+// setBeating clears the write deadline of the underlying connection for a
+// heartbeat, or restores the application's one afterwards.
+func (c *Conn) setBeating(beating bool) {
+	c.dmu.Lock()
+	defer c.dmu.Unlock()
+	c.beating = beating
+	if beating {
+		_ = c.Conn.SetWriteDeadline(time.Time{})
+	} else {
+		_ = c.Conn.SetWriteDeadline(c.wdl)
+	}
+}
+
+func writeFrame(w io.Writer, kind uint8, body []byte) (int, error) {
+	buf := make([]byte, frameHeader+len(body))
+	buf[0] = kind
+	binary.BigEndian.PutUint32(buf[1:frameHeader], uint32(len(body)))
+	copy(buf[frameHeader:], body)
+	return w.Write(buf)
+}
+
+func readFrame(r io.Reader) (uint8, []byte, error) {
+	var hdr [frameHeader]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	l := binary.BigEndian.Uint32(hdr[1:])
+	if l > maxFrame {
+		return 0, nil, fmt.Errorf("heartbeat: frame of %d bytes exceeds %d", l, maxFrame)
+	}
+	if hdr[0] < kindData || hdr[0] > kindPong {
+		return 0, nil, fmt.Errorf("heartbeat: unknown frame kind %d", hdr[0])
+	}
+	body := make([]byte, l)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return hdr[0], body, nil
+}
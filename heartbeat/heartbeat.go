@@ -0,0 +1,55 @@
+// Package heartbeat keeps connections alive and detects dead peers by
+// exchanging periodic ping and pong messages.
+package heartbeat
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// DefaultInterval is used when no positive interval is configured.
+const DefaultInterval = 5 * time.Second
+
+// Pinger writes "ping" to w every interval until ctx is done or a write
+// fails. A duration already waiting on reset sets the initial interval, and
+// every later one changes the interval at runtime; non-positive durations
+// keep the current interval.
+func Pinger(ctx context.Context, w io.Writer, reset <-chan time.Duration) {
+	_ = beat(ctx, reset, func() error {
+		_, err := w.Write([]byte("ping"))
+		return err
+	})
+}
+
+// beat calls send on every tick of the interval driven by reset. It returns
+// the error of send or of ctx.
+func beat(ctx context.Context, reset <-chan time.Duration, send func() error) error {
+	var interval time.Duration
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case interval = <-reset:
+	default:
+	}
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			if err := send(); err != nil {
+				return err
+			}
+		case d := <-reset:
+			if d > 0 {
+				interval = d
+			}
+		}
+		timer.Reset(interval)
+	}
+}
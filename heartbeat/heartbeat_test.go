@@ -0,0 +1,250 @@
+package heartbeat
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPinger(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, w := io.Pipe()
+	reset := make(chan time.Duration, 1)
+	reset <- time.Hour
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Pinger(ctx, w, reset)
+	}()
+
+	// Nothing is sent within the initial hour long interval until the
+	// interval is changed at runtime.
+	reset <- 10 * time.Millisecond
+	b := make([]byte, 4)
+	start := time.Now()
+	if _, err := io.ReadFull(r, b); err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "ping" {
+		t.Errorf("expected ping; actual %q", b)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the new interval to apply; waited %v", elapsed)
+	}
+	cancel()
+	<-done
+}
+
+// tcpPair returns both ends of a loopback TCP connection.
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- conn
+	}()
+	c1, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, ok := <-accepted
+	if !ok {
+		t.Fatal("accept failed")
+	}
+	return c1, c2
+}
+
+func TestConn(t *testing.T) {
+	c1, c2 := tcpPair(t)
+	a := New(c1, Config{Interval: 10 * time.Millisecond})
+	b := New(c2, Config{Interval: 10 * time.Millisecond})
+	defer a.Close()
+	defer b.Close()
+
+	go func() {
+		_, _ = a.Write([]byte("hello"))
+	}()
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(b, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "hello" {
+		t.Errorf("expected hello; actual %q", buf)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	for _, c := range []*Conn{a, b} {
+		s := c.Stats()
+		if s.Received == 0 || s.Avg <= 0 || s.Min > s.Max {
+			t.Errorf("expected pongs with round trip times; actual %+v", s)
+		}
+	}
+}
+
+func TestConnDead(t *testing.T) {
+	c1, c2 := tcpPair(t)
+	defer c2.Close()
+	// The peer swallows everything and never answers.
+	go func() {
+		_, _ = io.Copy(io.Discard, c2)
+	}()
+
+	dead := make(chan error, 1)
+	c := New(c1, Config{
+		Interval:  10 * time.Millisecond,
+		MaxMissed: 2,
+		OnDead:    func(err error) { dead <- err },
+	})
+	defer c.Close()
+
+	select {
+	case err := <-dead:
+		if !errors.Is(err, ErrDead) {
+			t.Errorf("expected ErrDead; actual %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("peer was not detected as dead")
+	}
+	if _, err := c.Read(make([]byte, 1)); !errors.Is(err, ErrDead) {
+		t.Errorf("expected Read to return ErrDead; actual %v", err)
+	}
+}
+
+func TestConnTrafficExtendsDeadline(t *testing.T) {
+	c1, c2 := tcpPair(t)
+	defer c2.Close()
+
+	dead := make(chan error, 1)
+	c := New(c1, Config{
+		Interval:  time.Hour,
+		MaxMissed: 1,
+		OnDead:    func(err error) { dead <- err },
+	})
+	defer c.Close()
+	// With an hour long interval no pings are sent; shrink the window so
+	// that only the data frames below keep the connection alive.
+	c.interval.Store(int64(30 * time.Millisecond))
+
+	go func() {
+		for range 10 {
+			if _, err := writeFrame(c2, kindData, []byte("x")); err != nil {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	buf := make([]byte, 10)
+	if _, err := io.ReadFull(c, buf); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-dead:
+		t.Fatalf("inbound data should have kept the peer alive: %v", err)
+	default:
+	}
+}
+
+func TestConnWriteDeadline(t *testing.T) {
+	c1, c2 := tcpPair(t)
+	dead := make(chan error, 1)
+	a := New(c1, Config{
+		Interval: 50 * time.Millisecond,
+		OnDead:   func(err error) { dead <- err },
+	})
+	b := New(c2, Config{Interval: 50 * time.Millisecond})
+	defer a.Close()
+	defer b.Close()
+
+	// The application bounds its own writes; the heartbeats sent after the
+	// deadline passed must not fail because of it.
+	if err := a.SetWriteDeadline(time.Now().Add(20 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	select {
+	case err := <-dead:
+		t.Fatalf("a live peer was declared dead: %v", err)
+	default:
+	}
+	if _, err := a.Write([]byte("late")); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("expected the application's write to time out; actual %v", err)
+	}
+	if s := a.Stats(); s.Received == 0 {
+		t.Errorf("expected heartbeats to keep flowing; actual %+v", s)
+	}
+}
+
+// stallConn times out a write once limit bytes were written.
+type stallConn struct {
+	net.Conn
+	mu    sync.Mutex
+	limit int
+}
+
+func (s *stallConn) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(b) <= s.limit {
+		s.limit -= len(b)
+		return s.Conn.Write(b)
+	}
+	n, _ := s.Conn.Write(b[:s.limit])
+	s.limit = 0
+	return n, os.ErrDeadlineExceeded
+}
+
+func TestConnWriteDeadlineMidFrame(t *testing.T) {
+	c1, c2 := tcpPair(t)
+	dead := make(chan error, 1)
+	a := New(&stallConn{Conn: c1, limit: maxFrame + 1000}, Config{Interval: 20 * time.Millisecond})
+	b := New(c2, Config{
+		Interval: 20 * time.Millisecond,
+		OnDead:   func(err error) { dead <- err },
+	})
+	defer a.Close()
+	defer b.Close()
+
+	sent := bytes.Repeat([]byte("x"), 2*maxFrame)
+	n, err := a.Write(sent)
+	if !errors.Is(err, os.ErrDeadlineExceeded) || n <= maxFrame || n >= len(sent) {
+		t.Fatalf("expected a write that timed out in the second frame; actual %d bytes, %v", n, err)
+	}
+	if _, err := a.Write([]byte("more")); err == nil {
+		t.Error("expected no more writes after a partial frame")
+	}
+
+	// b gets the whole frame, then the end of the connection rather than
+	// heartbeats read as the rest of the partial one.
+	if err := b.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	received, err := io.ReadAll(b)
+	if !bytes.Equal(received, sent[:maxFrame]) {
+		t.Errorf("expected the %d bytes of the whole frame; actual %d", maxFrame, len(received))
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("expected the connection to end; actual %v", err)
+	}
+	select {
+	case err := <-dead:
+		t.Errorf("a live peer was declared dead: %v", err)
+	default:
+	}
+}
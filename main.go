@@ -1,10 +1,17 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
-	"net"
+	"io"
+	"net/http"
 	"os"
+	"os/signal"
+	"slices"
+	"strings"
 	"time"
 )
 
@@ -12,36 +19,276 @@ var (
 	count    = flag.Int("count", 3, "how many times to ping?")
 	interval = flag.Duration("interval", 3*time.Second, "The interval between pings")
 	timeout  = flag.Duration("timeout", 2*time.Second, "The timeout")
+	output   = flag.String("output", "text", "output format: text, json or csv")
+	file     = flag.String("file", "", "read additional targets from file, one host:port per line")
+	workers  = flag.Int("workers", 8, "maximum number of concurrent probes")
+	network  = flag.String("network", "tcp", "tcp (connect only), or udp, unix, unixgram (echo the payload)")
+	payload  = flag.String("payload", "ping", "payload sent to echo services")
+	app      = flag.Bool("app", false, "exchange framed ping/pong messages over one persistent tcp or unix connection")
+
+	ipv4     = flag.Bool("4", false, "use IPv4 only")
+	ipv6     = flag.Bool("6", false, "use IPv6 only")
+	allAddrs = flag.Bool("all-addrs", false, "resolve every target and probe each of its addresses")
+	resolver = flag.String("resolver", "", "DNS server host:port to resolve targets with (default: system resolver)")
+
+	scanPorts = flag.Bool("scan", false, "scan the ports of host:ports targets, e.g. host:22,80,8000-8100")
+	serveAddr = flag.String("serve", "", "run as a Prometheus exporter on this address, e.g. :9115")
+
+	check = flag.Bool("check", false, "run as a Nagios/Icinga check plugin")
+	warn  = flag.String("warn", "100ms,20%", "check warning threshold: average RTT,loss%")
+	crit  = flag.String("crit", "500ms,60%", "check critical threshold: average RTT,loss%")
+
+	useTLS     = flag.Bool("tls", false, "perform a TLS handshake on every probe")
+	insecure   = flag.Bool("insecure", false, "skip TLS certificate verification")
+	serverName = flag.String("servername", "", "TLS server name (default: the target host)")
+	alpn       = flag.String("alpn", "", "comma separated ALPN protocols to offer, e.g. h2,http/1.1")
+	certExpiry = flag.Duration("cert-expiry", 30*24*time.Hour, "warn when the certificate expires within this window")
+
+	httpURL    = flag.String("http", "", "probe this URL with HTTP requests instead of TCP connects")
+	httpMethod = flag.String("method", http.MethodGet, "HTTP method used by -http")
 )
 
 func init() {
 	flag.Usage = func() {
-		fmt.Printf("Usage: %s [options] host:port\nOptions:\n", os.Args[0])
+		fmt.Printf("Usage: %s [options] host:port [host:port ...]\nOptions:\n", os.Args[0])
 		flag.PrintDefaults()
 	}
 }
 func main() {
 	flag.Parse()
-	if flag.NArg() != 1 {
-		fmt.Println("host:port is required")
-		flag.Usage()
-		os.Exit(1)
+	args := flag.Args()
+	if *httpURL != "" {
+		args = append([]string{*httpURL}, args...)
+	}
+	targets, err := loadTargets(args, *file)
+	if err != nil {
+		os.Exit(usageError(os.Stdout, err, false))
+	}
+	if len(targets) == 0 && *serveAddr == "" {
+		os.Exit(usageError(os.Stdout, errors.New("host:port is required"), true))
+	}
+	if err := conflictingFlags(); err != nil {
+		os.Exit(usageError(os.Stdout, err, false))
+	}
+	p, err := newProber()
+	if err != nil {
+		os.Exit(usageError(os.Stdout, err, true))
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if *scanPorts {
+		os.Exit(runScan(ctx, targets))
+	}
+	if *allAddrs {
+		if strings.HasPrefix(*network, "unix") {
+			os.Exit(usageError(os.Stdout, errors.New("-all-addrs cannot be used with unix sockets"), false))
+		}
+		targets, err = resolveTargets(ctx, newResolver(*resolver, *timeout), family(), targets)
+		if err != nil {
+			os.Exit(usageError(os.Stdout, err, false))
+		}
+	}
+	if *serveAddr != "" {
+		if err := serve(ctx, *serveAddr, targets, *interval, *workers, p); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *check {
+		os.Exit(int(runCheck(ctx, targets, p)))
+	}
+	var rep Reporter
+	if *output == "text" && len(targets) > 1 && isTerminal(os.Stdout) {
+		labels := make([]string, len(targets))
+		for i, t := range targets {
+			labels[i] = t.String()
+		}
+		rep = NewTableReporter(os.Stdout, labels)
+	} else {
+		rep, err = NewReporter(*output, os.Stdout)
+		if err != nil {
+			fmt.Println(err)
+			flag.Usage()
+			os.Exit(1)
+		}
 	}
 	if *count <= 0 {
-		fmt.Println("Ctrl + C to stop")
+		fmt.Fprintln(os.Stderr, "Ctrl + C to stop")
+	}
+
+	begin := time.Now()
+	stats := ping(ctx, targets, *count, *interval, *workers, p, rep)
+	elapsed := time.Since(begin)
+	if c, ok := p.(io.Closer); ok {
+		_ = c.Close()
+	}
+	code := 0
+	for i, target := range targets {
+		rep.Summary(target.String(), stats[i], elapsed)
+		if stats[i].Received == 0 {
+			code = 1
+		}
+	}
+	os.Exit(code)
+}
+
+// runScan scans every target and returns the exit code: 1 when no port of
+// any target is open.
+func runScan(ctx context.Context, targets []Target) int {
+	r := newResolver(*resolver, *timeout)
+	p := &tcpProber{timeout: *timeout, network: "tcp" + family(), resolver: r}
+	code := 1
+	for _, t := range targets {
+		host, ports, err := splitScanTarget(t.Name)
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		lookupCtx, cancel := context.WithTimeout(ctx, *timeout)
+		addr, err := resolveScanHost(lookupCtx, r, family(), host)
+		cancel()
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		results := scan(ctx, addr, ports, *workers, p)
+		if err := writeScanReport(os.Stdout, *output, host, addr, results); err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		if slices.ContainsFunc(results, func(r PortResult) bool { return r.State == PortOpen }) {
+			code = 0
+		}
+	}
+	return code
+}
+
+// runCheck pings targets quietly and prints a monitoring plugin status line.
+func runCheck(ctx context.Context, targets []Target, p Prober) CheckState {
+	w, err := parseThreshold(*warn)
+	if err != nil {
+		return unknown(os.Stdout, err)
 	}
+	c, err := parseThreshold(*crit)
+	if err != nil {
+		return unknown(os.Stdout, err)
+	}
+	stats := ping(ctx, targets, max(*count, 1), *interval, *workers, p, &textReporter{w: io.Discard})
+	line, state := checkReport(targets, stats, w, c)
+	fmt.Println(line)
+	return state
+}
+
+// usageError prints err, a usage or configuration error, to w and returns
+// the exit code for it. With -check it is reported as UNKNOWN, since
+// monitoring plugins read exit code 1 as WARNING.
+func usageError(w io.Writer, err error, usage bool) int {
+	if *check {
+		return int(unknown(w, err))
+	}
+	fmt.Fprintln(w, err)
+	if usage {
+		flag.Usage()
+	}
+	return 1
+}
 
-	var try int
-	for (*count <= 0) || (try <= *count) {
-		try++
-		fmt.Printf("try %v", try)
-		start := time.Now()
-		_, err := net.DialTimeout("tcp", flag.Arg(0), *timeout)
-		end := time.Since(start)
-		if err, ok := err.(net.Error); !ok || !err.Temporary() {
-			fmt.Printf("error: %v, after: %v", err, end)
-			os.Exit(0)
+// conflictingFlags returns an error for the first pair of flags that select
+// different modes, rather than letting one silently win over the other.
+func conflictingFlags() error {
+	set := map[string]bool{
+		"-4":         *ipv4,
+		"-6":         *ipv6,
+		"-http":      *httpURL != "",
+		"-tls":       *useTLS,
+		"-app":       *app,
+		"-network":   *network != "tcp",
+		"-scan":      *scanPorts,
+		"-serve":     *serveAddr != "",
+		"-check":     *check,
+		"-all-addrs": *allAddrs,
+	}
+	conflicts := [][2]string{
+		{"-4", "-6"},
+		{"-http", "-tls"},
+		{"-http", "-app"},
+		{"-http", "-network"},
+		{"-tls", "-app"},
+		{"-tls", "-network"},
+		{"-serve", "-check"},
+		// The daemon's /probe would keep a connection open for every
+		// target ever asked for.
+		{"-serve", "-app"},
+		{"-scan", "-serve"},
+		{"-scan", "-check"},
+		{"-scan", "-all-addrs"},
+		{"-scan", "-http"},
+		{"-scan", "-tls"},
+		{"-scan", "-app"},
+		{"-scan", "-network"},
+	}
+	for _, c := range conflicts {
+		if set[c[0]] && set[c[1]] {
+			return fmt.Errorf("%s and %s are mutually exclusive", c[0], c[1])
 		}
-		time.Sleep(*interval)
+	}
+	return nil
+}
+
+// family returns the IP family forced by -4 or -6: "4", "6" or "".
+func family() string {
+	switch {
+	case *ipv4:
+		return "4"
+	case *ipv6:
+		return "6"
+	default:
+		return ""
+	}
+}
+
+// newProber returns the Prober selected by the command line flags.
+func newProber() (Prober, error) {
+	cfg := &tls.Config{
+		ServerName:         *serverName,
+		InsecureSkipVerify: *insecure,
+	}
+	if *alpn != "" {
+		cfg.NextProtos = strings.Split(*alpn, ",")
+	}
+	res := newResolver(*resolver, *timeout)
+	switch {
+	case *httpURL != "":
+		p := newHTTPProber(*httpMethod, *timeout, cfg, *certExpiry)
+		p.network, p.resolver = "tcp"+family(), res
+		return p, nil
+	case *useTLS:
+		return &tlsProber{
+			timeout: *timeout, network: "tcp" + family(), resolver: res,
+			config: cfg, expiry: *certExpiry,
+		}, nil
+	}
+	if *app {
+		switch *network {
+		case "tcp":
+			return newAppProber("tcp"+family(), res, *timeout), nil
+		case "unix":
+			return newAppProber(*network, res, *timeout), nil
+		default:
+			return nil, fmt.Errorf("-app needs a tcp or unix network, not %q", *network)
+		}
+	}
+	switch *network {
+	case "tcp":
+		return &tcpProber{timeout: *timeout, network: "tcp" + family(), resolver: res}, nil
+	case "udp":
+		return &echoProber{
+			network: "udp" + family(), resolver: res, timeout: *timeout, payload: []byte(*payload),
+		}, nil
+	case "unix", "unixgram":
+		return &echoProber{network: *network, timeout: *timeout, payload: []byte(*payload)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported network %q", *network)
 	}
 }
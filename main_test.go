@@ -4,17 +4,20 @@ import (
 	"context"
 	"crypto/rand"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
+	"strings"
 	"sync"
 	"testing"
 	"time"
-)
 
-const DEFAULT_INTERVAL_PING_TIME = 5 * time.Second
+	"github.com/vfor4/gonet/heartbeat"
+	"github.com/vfor4/gonet/netx"
+)
 
 func xTestReadHugeData(t *testing.T) {
 	l, err := net.Listen("tcp", "127.0.0.1:")
@@ -60,10 +63,11 @@ func xTestReadHugeData(t *testing.T) {
 
 func xTestAdvancePinger(t *testing.T) {
 	done := make(chan struct{})
-	l, err := net.Listen("tcp", "127.0.0.1:")
+	tl, err := net.Listen("tcp", "127.0.0.1:")
 	if err != nil {
 		t.Fatal("failed to listen")
 	}
+	l := netx.NewIdleListener(tl, 5*time.Second, 5*time.Second)
 
 	begin := time.Now()
 	go func() {
@@ -72,7 +76,6 @@ func xTestAdvancePinger(t *testing.T) {
 		if err != nil {
 			fmt.Println(err)
 		}
-		err = conn.SetDeadline(time.Now().Add(5 * time.Second))
 		ctx, cancel := context.WithCancel(context.Background())
 		resetTimer := make(chan time.Duration, 1)
 		resetTimer <- time.Second
@@ -80,7 +83,7 @@ func xTestAdvancePinger(t *testing.T) {
 			cancel()
 			conn.Close()
 		}()
-		go Pinger(ctx, conn, resetTimer)
+		go heartbeat.Pinger(ctx, conn, resetTimer)
 		for {
 			b := make([]byte, 1024)
 			n, err := conn.Read(b)
@@ -88,7 +91,6 @@ func xTestAdvancePinger(t *testing.T) {
 				fmt.Println("Listener: failed to read", err)
 			}
 			fmt.Printf("Listener received: %s\n", b[:n])
-			err = conn.SetDeadline(time.Now().Add(5 * time.Second))
 		}
 	}()
 
@@ -122,47 +124,6 @@ func xTestAdvancePinger(t *testing.T) {
 	t.Logf("Done [%s]", end)
 }
 
-func Pinger(ctx context.Context, w io.Writer, resetTime <-chan time.Duration) {
-	var interval time.Duration
-	select {
-	case <-ctx.Done():
-	case interval = <-resetTime:
-	default:
-	}
-	if interval <= 0 {
-		interval = DEFAULT_INTERVAL_PING_TIME
-	}
-	fmt.Printf("Started pinger with interval %v\n", interval)
-	timer := time.NewTimer(interval)
-	defer func() {
-		if !timer.Stop() {
-			<-timer.C
-		}
-	}()
-	startedTime := time.Now()
-	for {
-		select {
-		case <-timer.C:
-			fmt.Printf("tick (%v)\n", time.Since(startedTime).Round(1000*time.Millisecond))
-			if _, err := w.Write([]byte("ping")); err != nil {
-				fmt.Printf("Failed to write ping command%v", err)
-				return
-			}
-		case <-ctx.Done():
-			fmt.Println("done")
-			return
-		case newInterval := <-resetTime:
-			if !timer.Stop() {
-				<-timer.C
-			}
-			if newInterval > 0 {
-				interval = newInterval
-			}
-		}
-		timer.Reset(interval)
-	}
-}
-
 func xTestBufferedChan(t *testing.T) {
 	t.Log("hi")
 	c := make(chan int)
@@ -185,7 +146,7 @@ func xTestNonBlockingPinger(t *testing.T) {
 	resetTimer <- 1 * time.Second
 
 	go func() {
-		Pinger(ctx, w, resetTimer)
+		heartbeat.Pinger(ctx, w, resetTimer)
 	}()
 
 	readPing := func(r io.Reader, d time.Duration) {
@@ -221,10 +182,11 @@ func xTestBlockingPinger(t *testing.T) {
 }
 
 func xTestDeadLine(t *testing.T) {
-	l, err := net.Listen("tcp", "127.0.0.1:")
+	tl, err := net.Listen("tcp", "127.0.0.1:")
 	if err != nil {
 		t.Log(err)
 	}
+	l := netx.NewIdleListener(tl, 5*time.Second, 0)
 	defer l.Close()
 	done := make(chan struct{})
 	go func() {
@@ -234,7 +196,6 @@ func xTestDeadLine(t *testing.T) {
 				t.Log(err)
 			}
 			defer conn.Close()
-			conn.SetDeadline(time.Now().Add(5 * time.Second))
 			log.Println("accepting...")
 			go func(c net.Conn) {
 				defer func() {
@@ -264,42 +225,22 @@ func xTestCancelMultiDialiers(t *testing.T) {
 		log.Println("accepting...")
 		if err != nil {
 			log.Println(err)
-			conn.Close()
+			return
 		}
+		conn.Close()
 	}()
 
-	ctx, cancel := context.WithCancel(context.Background())
-	var wg sync.WaitGroup
-	res := make(chan int)
-	d := func(ctx context.Context, addr string, wg *sync.WaitGroup, id int, res chan int) {
-		var d net.Dialer
-		dConn, err := d.Dial("tcp", addr)
-		log.Println(id, "is dialing...")
-		defer wg.Done()
-		if err != nil {
-			log.Println(err)
-		}
-		dConn.Close()
-
-		select {
-		case <-ctx.Done():
-		case res <- id:
-		}
+	addrs := make([]string, 10)
+	for i := range addrs {
+		addrs[i] = l.Addr().String()
 	}
-
-	for i := 0; i < 10; i++ {
-		wg.Add(1)
-		go d(ctx, l.Addr().String(), &wg, i, res)
-	}
-	response := <-res
-	cancel()
-	wg.Wait()
-	close(res)
-
-	if ctx.Err() != context.Canceled {
-		log.Printf("not cancel error %v\n", err)
+	d := &netx.RaceDialer{Stagger: 10 * time.Millisecond}
+	conn, err := d.DialRace(context.Background(), "tcp", addrs)
+	if err != nil {
+		t.Fatal(err)
 	}
-	log.Println("response:", response)
+	defer conn.Close()
+	log.Println("response:", conn.RemoteAddr())
 }
 
 func xTestNetTimeout(t *testing.T) {
@@ -371,3 +312,57 @@ func xTestNet(t *testing.T) {
 	<-done
 
 }
+
+func TestConflictingFlags(t *testing.T) {
+	tests := []struct {
+		flags    map[string]string
+		expected string
+	}{
+		{map[string]string{"tls": "true", "app": "true"}, "-tls and -app are mutually exclusive"},
+		{map[string]string{"http": "http://localhost/", "network": "udp"}, "-http and -network are mutually exclusive"},
+		{map[string]string{"scan": "true", "all-addrs": "true"}, "-scan and -all-addrs are mutually exclusive"},
+		{map[string]string{"serve": ":9115", "check": "true"}, "-serve and -check are mutually exclusive"},
+		{map[string]string{"serve": ":9115", "app": "true"}, "-serve and -app are mutually exclusive"},
+		{map[string]string{"4": "true", "6": "true"}, "-4 and -6 are mutually exclusive"},
+		{map[string]string{"app": "true", "network": "unix"}, ""},
+		{map[string]string{"tls": "true", "all-addrs": "true", "4": "true"}, ""},
+	}
+	for i, c := range tests {
+		for name, value := range c.flags {
+			f := flag.Lookup(name)
+			if err := f.Value.Set(value); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var actual string
+		if err := conflictingFlags(); err != nil {
+			actual = err.Error()
+		}
+		if actual != c.expected {
+			t.Errorf("%d: expected %q; actual %q", i, c.expected, actual)
+		}
+		for name := range c.flags {
+			f := flag.Lookup(name)
+			_ = f.Value.Set(f.DefValue)
+		}
+	}
+}
+
+func TestUsageError(t *testing.T) {
+	for _, c := range []struct {
+		check    bool
+		code     int
+		expected string
+	}{
+		{false, 1, "-tls and -app are mutually exclusive\n"},
+		{true, 3, "PING UNKNOWN - -tls and -app are mutually exclusive\n"},
+	} {
+		*check = c.check
+		out := new(strings.Builder)
+		code := usageError(out, errors.New("-tls and -app are mutually exclusive"), false)
+		if code != c.code || out.String() != c.expected {
+			t.Errorf("check %t: expected %d, %q; actual %d, %q", c.check, c.code, c.expected, code, out)
+		}
+	}
+	*check = false
+}
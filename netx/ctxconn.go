@@ -0,0 +1,156 @@
+package netx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// aLongTimeAgo is a deadline in the past, used to unblock pending I/O.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// binding ties the deadlines of a connection to a context. When the context
+// ends, every deadline moves into the past so that pending and future I/O
+// fails at once, and later deadline changes are ignored.
+type binding struct {
+	ctx  context.Context
+	stop func() bool
+
+	mu       sync.Mutex
+	done     bool
+	deadline func(time.Time) error
+}
+
+func bind(ctx context.Context, setDeadline func(time.Time) error) *binding {
+	b := &binding{ctx: ctx, deadline: setDeadline}
+	b.stop = context.AfterFunc(ctx, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.done = true
+		_ = b.deadline(aLongTimeAgo)
+	})
+	return b
+}
+
+// set applies a deadline set by the caller unless the context has ended.
+func (b *binding) set(f func(time.Time) error, t time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.done {
+		return nil
+	}
+	return f(t)
+}
+
+// wrap reports err as the context error when the context ended the I/O.
+func (b *binding) wrap(op string, netw string, src, dst net.Addr, err error) error {
+	if !endedBy(b.ctx, err) {
+		return err
+	}
+	return &net.OpError{Op: op, Net: netw, Source: src, Addr: dst, Err: b.ctx.Err()}
+}
+
+// endedBy reports whether err is a deadline error caused by ctx ending.
+func endedBy(ctx context.Context, err error) bool {
+	return err != nil && errors.Is(err, os.ErrDeadlineExceeded) && ctx.Err() != nil
+}
+
+// Bind ties deadlines to ctx like WithContext, for connections it cannot
+// wrap, such as framed ones: once ctx is done, setDeadline is called with a
+// deadline in the past. stop releases the binding.
+func Bind(ctx context.Context, setDeadline func(time.Time) error) (stop func() bool) {
+	return bind(ctx, setDeadline).stop
+}
+
+// ContextError returns err wrapped with ctx.Err() when ctx ending caused
+// it, for I/O bound with Bind, and err otherwise.
+func ContextError(ctx context.Context, err error) error {
+	if !endedBy(ctx, err) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ctx.Err(), err)
+}
+
+// ContextConn is a net.Conn bound to a context. Once the context is done,
+// blocked and future reads and writes return a *net.OpError that wraps
+// ctx.Err(). Close releases the binding; it must be called even if the
+// context ends first.
+type ContextConn struct {
+	net.Conn
+	b *binding
+}
+
+// WithContext binds conn to ctx.
+func WithContext(ctx context.Context, conn net.Conn) *ContextConn {
+	return &ContextConn{Conn: conn, b: bind(ctx, conn.SetDeadline)}
+}
+
+func (c *ContextConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	return n, c.b.wrap("read", c.LocalAddr().Network(), c.LocalAddr(), c.RemoteAddr(), err)
+}
+
+func (c *ContextConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	return n, c.b.wrap("write", c.LocalAddr().Network(), c.LocalAddr(), c.RemoteAddr(), err)
+}
+
+func (c *ContextConn) SetDeadline(t time.Time) error {
+	return c.b.set(c.Conn.SetDeadline, t)
+}
+
+func (c *ContextConn) SetReadDeadline(t time.Time) error {
+	return c.b.set(c.Conn.SetReadDeadline, t)
+}
+
+func (c *ContextConn) SetWriteDeadline(t time.Time) error {
+	return c.b.set(c.Conn.SetWriteDeadline, t)
+}
+
+func (c *ContextConn) Close() error {
+	c.b.stop()
+	return c.Conn.Close()
+}
+
+// ContextPacketConn is a net.PacketConn bound to a context, with the same
+// semantics as ContextConn.
+type ContextPacketConn struct {
+	net.PacketConn
+	b *binding
+}
+
+// WithContextPacket binds conn to ctx.
+func WithContextPacket(ctx context.Context, conn net.PacketConn) *ContextPacketConn {
+	return &ContextPacketConn{PacketConn: conn, b: bind(ctx, conn.SetDeadline)}
+}
+
+func (c *ContextPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(b)
+	return n, addr, c.b.wrap("read", c.LocalAddr().Network(), c.LocalAddr(), addr, err)
+}
+
+func (c *ContextPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	n, err := c.PacketConn.WriteTo(b, addr)
+	return n, c.b.wrap("write", c.LocalAddr().Network(), c.LocalAddr(), addr, err)
+}
+
+func (c *ContextPacketConn) SetDeadline(t time.Time) error {
+	return c.b.set(c.PacketConn.SetDeadline, t)
+}
+
+func (c *ContextPacketConn) SetReadDeadline(t time.Time) error {
+	return c.b.set(c.PacketConn.SetReadDeadline, t)
+}
+
+func (c *ContextPacketConn) SetWriteDeadline(t time.Time) error {
+	return c.b.set(c.PacketConn.SetWriteDeadline, t)
+}
+
+func (c *ContextPacketConn) Close() error {
+	c.b.stop()
+	return c.PacketConn.Close()
+}
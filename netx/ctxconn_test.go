@@ -0,0 +1,75 @@
+package netx
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestContextConn(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	conn := WithContext(ctx, c1)
+	defer conn.Close()
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(make([]byte, 1))
+		errs <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled; actual %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("read was not unblocked by the cancellation")
+	}
+
+	// A deadline set after the cancellation must not revive the conn.
+	_ = conn.SetDeadline(time.Now().Add(time.Hour))
+	if _, err := conn.Write([]byte("x")); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled; actual %v", err)
+	}
+}
+
+func TestContextPacketConn(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	conn := WithContextPacket(ctx, pc)
+	defer conn.Close()
+
+	_, _, err = conn.ReadFrom(make([]byte, 1))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded; actual %v", err)
+	}
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Error("expected a net.Error timeout")
+	}
+}
+
+func TestContextConnClose(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	conn := WithContext(ctx, c1)
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Close must have released the context callback.
+	if conn.b.stop() {
+		t.Error("expected the context binding to be stopped by Close")
+	}
+}
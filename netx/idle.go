@@ -0,0 +1,111 @@
+// Package netx provides net.Conn and net.Listener wrappers shared by our
+// servers and clients.
+package netx
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// IdleTimeoutError is returned by an IdleConn whose peer stayed idle for
+// longer than the timeout of the operation. It wraps os.ErrDeadlineExceeded
+// and is a net.Error whose Timeout method reports true.
+type IdleTimeoutError struct {
+	Op   string
+	Idle time.Duration
+	Err  error
+}
+
+func (e *IdleTimeoutError) Error() string {
+	return fmt.Sprintf("%s idle for more than %v: %v", e.Op, e.Idle, e.Err)
+}
+
+func (e *IdleTimeoutError) Unwrap() error { return e.Err }
+
+func (e *IdleTimeoutError) Timeout() bool { return true }
+
+func (e *IdleTimeoutError) Temporary() bool { return false }
+
+// IdleConn is a net.Conn that fails reads and writes which make no progress
+// for ReadTimeout and WriteTimeout respectively. The deadline slides forward
+// on every Read and Write, so a connection with steady traffic never times
+// out. A zero timeout disables the idle check for that direction.
+//
+// IdleConn owns the deadlines of the directions it times out; deadlines set
+// by the caller are replaced on the next Read or Write.
+type IdleConn struct {
+	net.Conn
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+}
+
+// NewIdleConn wraps conn with the given idle timeouts.
+func NewIdleConn(conn net.Conn, readTimeout, writeTimeout time.Duration) *IdleConn {
+	return &IdleConn{Conn: conn, ReadTimeout: readTimeout, WriteTimeout: writeTimeout}
+}
+
+func (c *IdleConn) Read(b []byte) (int, error) {
+	if c.ReadTimeout > 0 {
+		if err := c.Conn.SetReadDeadline(time.Now().Add(c.ReadTimeout)); err != nil {
+			return 0, err
+		}
+	}
+	n, err := c.Conn.Read(b)
+	return n, c.wrap("read", c.ReadTimeout, err)
+}
+
+func (c *IdleConn) Write(b []byte) (int, error) {
+	if c.WriteTimeout <= 0 {
+		return c.Conn.Write(b)
+	}
+	// Write in chunks so that a large, slowly progressing write is not
+	// mistaken for an idle peer.
+	var n int
+	for len(b) > 0 {
+		if err := c.Conn.SetWriteDeadline(time.Now().Add(c.WriteTimeout)); err != nil {
+			return n, err
+		}
+		chunk := b[:min(len(b), writeChunk)]
+		m, err := c.Conn.Write(chunk)
+		n += m
+		if err != nil {
+			return n, c.wrap("write", c.WriteTimeout, err)
+		}
+		b = b[m:]
+	}
+	return n, nil
+}
+
+// writeChunk bounds how much an IdleConn writes under a single deadline.
+const writeChunk = 32 << 10
+
+func (c *IdleConn) wrap(op string, timeout time.Duration, err error) error {
+	if timeout <= 0 || !errors.Is(err, os.ErrDeadlineExceeded) {
+		return err
+	}
+	return &IdleTimeoutError{Op: op, Idle: timeout, Err: err}
+}
+
+// IdleListener wraps every accepted connection in an IdleConn.
+type IdleListener struct {
+	net.Listener
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+}
+
+// NewIdleListener returns a listener whose connections use the given idle
+// timeouts.
+func NewIdleListener(l net.Listener, readTimeout, writeTimeout time.Duration) *IdleListener {
+	return &IdleListener{Listener: l, ReadTimeout: readTimeout, WriteTimeout: writeTimeout}
+}
+
+func (l *IdleListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return NewIdleConn(conn, l.ReadTimeout, l.WriteTimeout), nil
+}
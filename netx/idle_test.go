@@ -0,0 +1,104 @@
+package netx
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestIdleListener(t *testing.T) {
+	tl, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewIdleListener(tl, 50*time.Millisecond, 0)
+	defer l.Close()
+
+	errs := make(chan error, 1)
+	received := make(chan int, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			received <- 0
+			errs <- err
+			return
+		}
+		defer conn.Close()
+		var total int
+		buf := make([]byte, 16)
+		for {
+			n, err := conn.Read(buf)
+			total += n
+			if err != nil {
+				received <- total
+				errs <- err
+				return
+			}
+		}
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// Steady traffic for longer than the idle timeout keeps the conn alive.
+	for range 5 {
+		if _, err := conn.Write([]byte("x")); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if n := <-received; n != 5 {
+		t.Errorf("expected 5 bytes before the idle timeout; actual %d", n)
+	}
+	err = <-errs
+	var idleErr *IdleTimeoutError
+	if !errors.As(err, &idleErr) || idleErr.Op != "read" {
+		t.Fatalf("expected a read IdleTimeoutError; actual %v", err)
+	}
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Error("expected the error to wrap os.ErrDeadlineExceeded")
+	}
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Error("expected a net.Error timeout")
+	}
+}
+
+func TestIdleConnWrite(t *testing.T) {
+	c1, c2 := net.Pipe()
+	conn := NewIdleConn(c1, 0, 30*time.Millisecond)
+	defer conn.Close()
+
+	// A reader that keeps up lets a write longer than the timeout finish.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 4096)
+		for {
+			if _, err := c2.Read(buf); err != nil {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	if _, err := conn.Write(make([]byte, 100<<10)); err != nil {
+		t.Fatal(err)
+	}
+	_ = c2.Close()
+	<-done
+
+	// Nobody reads anymore, so the next write goes idle.
+	c3, c4 := net.Pipe()
+	defer c4.Close()
+	conn = NewIdleConn(c3, 0, 30*time.Millisecond)
+	_, err := conn.Write([]byte("stuck"))
+	var idleErr *IdleTimeoutError
+	if !errors.As(err, &idleErr) || idleErr.Op != "write" {
+		t.Errorf("expected a write IdleTimeoutError; actual %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+package netx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// DefaultStagger is the delay between two connection attempts of a
+// RaceDialer, the value recommended by RFC 8305.
+const DefaultStagger = 250 * time.Millisecond
+
+// RaceDialer dials several addresses concurrently and keeps the first
+// connection that is established, Happy Eyeballs style. Attempts start
+// Stagger apart, or as soon as the previous one fails; the losers are
+// canceled and closed.
+//
+// DialContext has the signature of http.Transport.DialContext, and
+//
+//	grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+//		return d.DialContext(ctx, "tcp", addr)
+//	})
+//
+// plugs it into a gRPC client.
+type RaceDialer struct {
+	// Dialer makes every attempt. The zero value is used when nil.
+	Dialer *net.Dialer
+	// Resolver looks up the addresses of a host. The default resolver is
+	// used when nil.
+	Resolver *net.Resolver
+	// Stagger is the delay before the next attempt starts. DefaultStagger
+	// is used when zero.
+	Stagger time.Duration
+}
+
+// AttemptError is the failure of a single connection attempt.
+type AttemptError struct {
+	Addr string
+	Err  error
+}
+
+func (e *AttemptError) Error() string { return e.Addr + ": " + e.Err.Error() }
+
+func (e *AttemptError) Unwrap() error { return e.Err }
+
+// RaceError is returned when every attempt of a race failed. Attempts are in
+// the order they failed.
+type RaceError struct {
+	Attempts []*AttemptError
+}
+
+func (e *RaceError) Error() string {
+	msgs := make([]string, len(e.Attempts))
+	for i, a := range e.Attempts {
+		msgs[i] = a.Error()
+	}
+	return fmt.Sprintf("all %d attempts failed: %s", len(e.Attempts), strings.Join(msgs, "; "))
+}
+
+func (e *RaceError) Unwrap() []error {
+	errs := make([]error, len(e.Attempts))
+	for i, a := range e.Attempts {
+		errs[i] = a
+	}
+	return errs
+}
+
+// DialContext resolves the host of address and races every IP address it
+// resolves to, alternating IPv6 and IPv4 addresses as RFC 8305 recommends.
+// A tcp4 or tcp6 network restricts the race to that family.
+func (d *RaceDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) != nil {
+		return d.DialRace(ctx, network, []string{address})
+	}
+	r := d.Resolver
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	ipNet := "ip"
+	if strings.HasSuffix(network, "4") || strings.HasSuffix(network, "6") {
+		ipNet += network[len(network)-1:]
+	}
+	ips, err := r.LookupNetIP(ctx, ipNet, host)
+	if err != nil {
+		return nil, err
+	}
+	var v6, v4 []string
+	for _, ip := range ips {
+		addr := net.JoinHostPort(ip.Unmap().String(), port)
+		if ip.Unmap().Is4() {
+			v4 = append(v4, addr)
+		} else {
+			v6 = append(v6, addr)
+		}
+	}
+	addrs := make([]string, 0, len(ips))
+	for i := 0; i < max(len(v6), len(v4)); i++ {
+		if i < len(v6) {
+			addrs = append(addrs, v6[i])
+		}
+		if i < len(v4) {
+			addrs = append(addrs, v4[i])
+		}
+	}
+	return d.DialRace(ctx, network, addrs)
+}
+
+// DialRace races addrs in the given order and returns the first connection
+// established. When every attempt fails the error is a *RaceError.
+func (d *RaceDialer) DialRace(ctx context.Context, network string, addrs []string) (net.Conn, error) {
+	if len(addrs) == 0 {
+		return nil, errors.New("no address to dial")
+	}
+	dialer := d.Dialer
+	if dialer == nil {
+		dialer = new(net.Dialer)
+	}
+	stagger := d.Stagger
+	if stagger <= 0 {
+		stagger = DefaultStagger
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type attempt struct {
+		addr string
+		conn net.Conn
+		err  error
+	}
+	results := make(chan attempt, len(addrs))
+	next, pending := 0, 0
+	start := func() {
+		addr := addrs[next]
+		next++
+		pending++
+		go func() {
+			conn, err := dialer.DialContext(ctx, network, addr)
+			results <- attempt{addr: addr, conn: conn, err: err}
+		}()
+	}
+
+	start()
+	timer := time.NewTimer(stagger)
+	defer timer.Stop()
+	race := new(RaceError)
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if next < len(addrs) {
+				start()
+				timer.Reset(stagger)
+			}
+		case a := <-results:
+			pending--
+			if a.err == nil {
+				// The deferred cancel aborts the other attempts; close the
+				// ones that connected anyway.
+				go func(n int) {
+					for range n {
+						if l := <-results; l.conn != nil {
+							_ = l.conn.Close()
+						}
+					}
+				}(pending)
+				return a.conn, nil
+			}
+			race.Attempts = append(race.Attempts, &AttemptError{Addr: a.addr, Err: a.err})
+			if next < len(addrs) {
+				start()
+				timer.Reset(stagger)
+			}
+		}
+	}
+	return nil, race
+}
@@ -0,0 +1,99 @@
+package netx
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRaceDialer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	good := l.Addr().String()
+
+	// slow never gets past the control hook until its attempt is canceled.
+	slow, canceled := "127.0.0.2:1", make(chan struct{})
+	d := &RaceDialer{
+		Stagger: 20 * time.Millisecond,
+		Dialer: &net.Dialer{
+			ControlContext: func(ctx context.Context, _, addr string, _ syscall.RawConn) error {
+				if addr == slow {
+					<-ctx.Done()
+					close(canceled)
+					return ctx.Err()
+				}
+				return nil
+			},
+		},
+	}
+
+	begin := time.Now()
+	conn, err := d.DialRace(context.Background(), "tcp", []string{slow, good})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.RemoteAddr().String() != good {
+		t.Errorf("expected a conn to %s; actual %s", good, conn.RemoteAddr())
+	}
+	if elapsed := time.Since(begin); elapsed < d.Stagger {
+		t.Errorf("expected the second attempt to wait %v; it won after %v", d.Stagger, elapsed)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Error("expected the losing attempt to be canceled")
+	}
+}
+
+func TestRaceDialerAllFail(t *testing.T) {
+	var addrs []string
+	for range 2 {
+		l, err := net.Listen("tcp", "127.0.0.1:")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addrs = append(addrs, l.Addr().String())
+		_ = l.Close()
+	}
+
+	d := &RaceDialer{Stagger: time.Second}
+	begin := time.Now()
+	_, err := d.DialRace(context.Background(), "tcp", addrs)
+	var race *RaceError
+	if !errors.As(err, &race) {
+		t.Fatalf("expected a RaceError; actual %v", err)
+	}
+	if len(race.Attempts) != len(addrs) {
+		t.Errorf("expected %d attempt errors; actual %d", len(addrs), len(race.Attempts))
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Errorf("expected the attempts to be refused; actual %v", err)
+	}
+	// A failed attempt starts the next one without waiting for the stagger.
+	if elapsed := time.Since(begin); elapsed >= d.Stagger {
+		t.Errorf("expected failures to skip the stagger; took %v", elapsed)
+	}
+}
+
+func TestRaceDialerResolve(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	d := new(RaceDialer)
+	conn, err := d.DialContext(context.Background(), "tcp4", net.JoinHostPort("localhost", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+}
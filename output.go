@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Result is the outcome of a single probe.
+type Result struct {
+	Time   time.Time
+	Target string
+	Addr   net.Addr
+	Seq    int
+	RTT    time.Duration
+	Err    error
+
+	// Connect is the TCP connect time when the probe does more than connect.
+	Connect time.Duration
+	TLS     *TLSInfo
+	HTTP    *HTTPInfo
+}
+
+// Reporter renders probe results and the final statistics of a target.
+type Reporter interface {
+	Probe(r Result)
+	Summary(target string, s *Stats, elapsed time.Duration)
+}
+
+// NewReporter returns the Reporter for the given -output mode.
+func NewReporter(mode string, w io.Writer) (Reporter, error) {
+	switch mode {
+	case "text":
+		return &textReporter{w: w}, nil
+	case "json":
+		return &jsonReporter{enc: json.NewEncoder(w)}, nil
+	case "csv":
+		return &csvReporter{w: csv.NewWriter(w)}, nil
+	default:
+		return nil, fmt.Errorf("unknown output mode %q", mode)
+	}
+}
+
+// errorClass maps a probe error to a short, stable name that scripts can
+// match on without parsing error strings.
+func errorClass(err error) string {
+	var (
+		dnsErr     *net.DNSError
+		netErr     net.Error
+		alertErr   tls.AlertError
+		recordErr  tls.RecordHeaderError
+		verifyErr  *tls.CertificateVerificationError
+		hostErr    x509.HostnameError
+		unknownErr x509.UnknownAuthorityError
+		invalidErr x509.CertificateInvalidError
+	)
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.Is(err, ErrEchoMismatch):
+		return "mismatch"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "refused"
+	case errors.Is(err, syscall.ECONNRESET):
+		return "reset"
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return "unreachable"
+	case errors.As(err, &verifyErr), errors.As(err, &hostErr),
+		errors.As(err, &unknownErr), errors.As(err, &invalidErr):
+		return "certificate"
+	case errors.As(err, &alertErr), errors.As(err, &recordErr):
+		return "tls"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	default:
+		return "other"
+	}
+}
+
+type textReporter struct {
+	w io.Writer
+}
+
+func (t *textReporter) Probe(r Result) {
+	if r.Err != nil {
+		fmt.Fprintf(t.w, "seq=%d error: %v, after: %v\n", r.Seq, r.Err, r.RTT)
+		return
+	}
+	fmt.Fprintf(t.w, "connected to %s: seq=%d time=%v\n", r.Addr, r.Seq, r.RTT)
+	if h := r.HTTP; h != nil {
+		var handshake time.Duration
+		if r.TLS != nil {
+			handshake = r.TLS.Handshake
+		}
+		fmt.Fprintf(t.w, "    status=%d size=%d dns=%v connect=%v tls=%v ttfb=%v total=%v\n",
+			h.Status, h.BodySize, h.DNS, r.Connect, handshake, h.TTFB, r.RTT)
+	}
+	if i := r.TLS; i != nil {
+		fmt.Fprintf(t.w, "    connect=%v handshake=%v %s %s alpn=%q\n",
+			r.Connect, i.Handshake, i.Version, i.CipherSuite, i.ALPN)
+		fmt.Fprintf(t.w, "    subject=%q expires=%s\n", i.Subject, i.NotAfter.Format(time.DateOnly))
+		if i.ExpiresSoon {
+			fmt.Fprintf(t.w, "    WARNING: certificate expires in %v\n", time.Until(i.NotAfter).Round(time.Hour))
+		}
+	}
+}
+
+func (t *textReporter) Summary(target string, s *Stats, elapsed time.Duration) {
+	s.Summary(t.w, target, elapsed)
+}
+
+type probeRecord struct {
+	Type       string      `json:"type"`
+	Timestamp  time.Time   `json:"timestamp"`
+	Target     string      `json:"target"`
+	Addr       string      `json:"addr,omitempty"`
+	Seq        int         `json:"seq"`
+	RTTMs      float64     `json:"rtt_ms"`
+	ErrorClass string      `json:"error_class,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	ConnectMs  float64     `json:"connect_ms,omitempty"`
+	TLS        *tlsRecord  `json:"tls,omitempty"`
+	HTTP       *httpRecord `json:"http,omitempty"`
+}
+
+type httpRecord struct {
+	DNSMs    float64 `json:"dns_ms"`
+	TTFBMs   float64 `json:"ttfb_ms"`
+	Status   int     `json:"status"`
+	BodySize int64   `json:"body_bytes"`
+}
+
+type tlsRecord struct {
+	HandshakeMs  float64    `json:"handshake_ms"`
+	Version      string     `json:"version"`
+	CipherSuite  string     `json:"cipher_suite"`
+	ALPN         string     `json:"alpn,omitempty"`
+	CertSubject  string     `json:"cert_subject,omitempty"`
+	CertNotAfter *time.Time `json:"cert_not_after,omitempty"`
+	ExpiresSoon  bool       `json:"cert_expires_soon"`
+}
+
+type summaryRecord struct {
+	Type      string    `json:"type"`
+	Timestamp time.Time `json:"timestamp"`
+	Target    string    `json:"target"`
+	Sent      int       `json:"sent"`
+	Received  int       `json:"received"`
+	Loss      float64   `json:"loss_percent"`
+	ElapsedMs float64   `json:"elapsed_ms"`
+	MinMs     float64   `json:"min_ms"`
+	AvgMs     float64   `json:"avg_ms"`
+	MaxMs     float64   `json:"max_ms"`
+	MdevMs    float64   `json:"mdev_ms"`
+	P50Ms     float64   `json:"p50_ms"`
+	P90Ms     float64   `json:"p90_ms"`
+	P99Ms     float64   `json:"p99_ms"`
+}
+
+func newProbeRecord(r Result) probeRecord {
+	rec := probeRecord{
+		Type:       "probe",
+		Timestamp:  r.Time,
+		Target:     r.Target,
+		Seq:        r.Seq,
+		RTTMs:      ms(r.RTT),
+		ErrorClass: errorClass(r.Err),
+		ConnectMs:  ms(r.Connect),
+	}
+	if r.Addr != nil {
+		rec.Addr = r.Addr.String()
+	}
+	if r.Err != nil {
+		rec.Error = r.Err.Error()
+	}
+	if i := r.TLS; i != nil {
+		rec.TLS = &tlsRecord{
+			HandshakeMs: ms(i.Handshake),
+			Version:     i.Version,
+			CipherSuite: i.CipherSuite,
+			ALPN:        i.ALPN,
+			CertSubject: i.Subject,
+			ExpiresSoon: i.ExpiresSoon,
+		}
+		if !i.NotAfter.IsZero() {
+			notAfter := i.NotAfter
+			rec.TLS.CertNotAfter = &notAfter
+		}
+	}
+	if h := r.HTTP; h != nil {
+		rec.HTTP = &httpRecord{
+			DNSMs:    ms(h.DNS),
+			TTFBMs:   ms(h.TTFB),
+			Status:   h.Status,
+			BodySize: h.BodySize,
+		}
+	}
+	return rec
+}
+
+func newSummaryRecord(target string, s *Stats, elapsed time.Duration) summaryRecord {
+	return summaryRecord{
+		Type:      "summary",
+		Timestamp: time.Now(),
+		Target:    target,
+		Sent:      s.Sent,
+		Received:  s.Received,
+		Loss:      s.Loss(),
+		ElapsedMs: ms(elapsed),
+		MinMs:     ms(s.Min()),
+		AvgMs:     ms(s.Avg()),
+		MaxMs:     ms(s.Max()),
+		MdevMs:    ms(s.Mdev()),
+		P50Ms:     ms(s.Percentile(50)),
+		P90Ms:     ms(s.Percentile(90)),
+		P99Ms:     ms(s.Percentile(99)),
+	}
+}
+
+type jsonReporter struct {
+	enc *json.Encoder
+}
+
+func (j *jsonReporter) Probe(r Result) {
+	_ = j.enc.Encode(newProbeRecord(r))
+}
+
+func (j *jsonReporter) Summary(target string, s *Stats, elapsed time.Duration) {
+	_ = j.enc.Encode(newSummaryRecord(target, s, elapsed))
+}
+
+// csvHeader is shared by probe and summary rows; the type column tells them
+// apart and the columns that do not apply are left empty.
+var csvHeader = []string{
+	"type", "timestamp", "target", "addr", "seq", "rtt_ms", "error_class", "error",
+	"sent", "received", "loss_percent", "elapsed_ms",
+	"min_ms", "avg_ms", "max_ms", "mdev_ms", "p50_ms", "p90_ms", "p99_ms",
+	"connect_ms", "tls_handshake_ms", "tls_version", "tls_cipher_suite", "tls_alpn",
+	"cert_subject", "cert_not_after", "cert_expires_soon",
+	"dns_ms", "ttfb_ms", "http_status", "body_bytes",
+}
+
+type csvReporter struct {
+	w           *csv.Writer
+	wroteHeader bool
+}
+
+// write emits fields in csvHeader order.
+func (c *csvReporter) write(fields map[string]string) {
+	if !c.wroteHeader {
+		_ = c.w.Write(csvHeader)
+		c.wroteHeader = true
+	}
+	row := make([]string, len(csvHeader))
+	for i, h := range csvHeader {
+		row[i] = fields[h]
+	}
+	_ = c.w.Write(row)
+	c.w.Flush()
+}
+
+func (c *csvReporter) Probe(r Result) {
+	rec := newProbeRecord(r)
+	fields := map[string]string{
+		"type":        rec.Type,
+		"timestamp":   rec.Timestamp.Format(time.RFC3339Nano),
+		"target":      rec.Target,
+		"addr":        rec.Addr,
+		"seq":         strconv.Itoa(rec.Seq),
+		"rtt_ms":      formatFloat(rec.RTTMs),
+		"error_class": rec.ErrorClass,
+		"error":       rec.Error,
+	}
+	if rec.ConnectMs > 0 {
+		fields["connect_ms"] = formatFloat(rec.ConnectMs)
+	}
+	if t := rec.TLS; t != nil {
+		fields["tls_handshake_ms"] = formatFloat(t.HandshakeMs)
+		fields["tls_version"] = t.Version
+		fields["tls_cipher_suite"] = t.CipherSuite
+		fields["tls_alpn"] = t.ALPN
+		fields["cert_subject"] = t.CertSubject
+		if t.CertNotAfter != nil {
+			fields["cert_not_after"] = t.CertNotAfter.Format(time.RFC3339)
+		}
+		fields["cert_expires_soon"] = strconv.FormatBool(t.ExpiresSoon)
+	}
+	if h := rec.HTTP; h != nil {
+		fields["dns_ms"] = formatFloat(h.DNSMs)
+		fields["ttfb_ms"] = formatFloat(h.TTFBMs)
+		fields["http_status"] = strconv.Itoa(h.Status)
+		fields["body_bytes"] = strconv.FormatInt(h.BodySize, 10)
+	}
+	c.write(fields)
+}
+
+func (c *csvReporter) Summary(target string, s *Stats, elapsed time.Duration) {
+	rec := newSummaryRecord(target, s, elapsed)
+	c.write(map[string]string{
+		"type":         rec.Type,
+		"timestamp":    rec.Timestamp.Format(time.RFC3339Nano),
+		"target":       rec.Target,
+		"sent":         strconv.Itoa(rec.Sent),
+		"received":     strconv.Itoa(rec.Received),
+		"loss_percent": formatFloat(rec.Loss),
+		"elapsed_ms":   formatFloat(rec.ElapsedMs),
+		"min_ms":       formatFloat(rec.MinMs),
+		"avg_ms":       formatFloat(rec.AvgMs),
+		"max_ms":       formatFloat(rec.MaxMs),
+		"mdev_ms":      formatFloat(rec.MdevMs),
+		"p50_ms":       formatFloat(rec.P50Ms),
+		"p90_ms":       formatFloat(rec.P90Ms),
+		"p99_ms":       formatFloat(rec.P99Ms),
+	})
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', 3, 64)
+}
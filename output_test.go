@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestErrorClass(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected string
+	}{
+		{nil, ""},
+		{&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, "refused"},
+		{&net.DNSError{Err: "no such host", Name: "nope.invalid"}, "dns"},
+		{&net.OpError{Op: "dial", Err: &timeoutError{}}, "timeout"},
+		{errors.New("boom"), "other"},
+	}
+	for i, c := range tests {
+		if actual := errorClass(c.err); actual != c.expected {
+			t.Errorf("%d: expected %q; actual %q", i, c.expected, actual)
+		}
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestJSONReporter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	rep, err := NewReporter("json", buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := new(Stats)
+	addr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 80}
+	s.Add(2*time.Millisecond, nil)
+	rep.Probe(Result{Time: time.Now(), Target: "localhost:80", Addr: addr, Seq: 1, RTT: 2 * time.Millisecond})
+	s.Add(0, syscall.ECONNREFUSED)
+	rep.Probe(Result{Time: time.Now(), Target: "localhost:80", Seq: 2, Err: syscall.ECONNREFUSED})
+	rep.Summary("localhost:80", s, time.Second)
+
+	dec := json.NewDecoder(buf)
+	var probes []probeRecord
+	for range 2 {
+		var rec probeRecord
+		if err := dec.Decode(&rec); err != nil {
+			t.Fatal(err)
+		}
+		probes = append(probes, rec)
+	}
+	if probes[0].Addr != "127.0.0.1:80" || probes[0].RTTMs != 2 {
+		t.Errorf("unexpected probe record %+v", probes[0])
+	}
+	if probes[1].ErrorClass != "refused" {
+		t.Errorf("expected error class refused; actual %q", probes[1].ErrorClass)
+	}
+	var sum summaryRecord
+	if err := dec.Decode(&sum); err != nil {
+		t.Fatal(err)
+	}
+	if sum.Type != "summary" || sum.Sent != 2 || sum.Loss != 50 {
+		t.Errorf("unexpected summary record %+v", sum)
+	}
+}
+
+func TestJSONReporterTLS(t *testing.T) {
+	notAfter := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		info     TLSInfo
+		expected string
+	}{
+		{TLSInfo{Version: "TLS 1.3", NotAfter: notAfter}, `"cert_not_after":"2030-01-01T00:00:00Z"`},
+		{TLSInfo{Version: "TLS 1.3"}, ""},
+	} {
+		buf := new(bytes.Buffer)
+		rep, err := NewReporter("json", buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rep.Probe(Result{Time: time.Now(), Target: "localhost:443", Seq: 1, TLS: &c.info})
+		actual := buf.String()
+		if c.expected == "" && strings.Contains(actual, "cert_not_after") {
+			t.Errorf("expected no cert_not_after without a certificate; actual %s", actual)
+		}
+		if c.expected != "" && !strings.Contains(actual, c.expected) {
+			t.Errorf("expected %s; actual %s", c.expected, actual)
+		}
+	}
+}
+
+func TestCSVReporter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	rep, err := NewReporter("csv", buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := new(Stats)
+	s.Add(time.Millisecond, nil)
+	rep.Probe(Result{Time: time.Now(), Target: "localhost:80", Seq: 1, RTT: time.Millisecond})
+	rep.Summary("localhost:80", s, time.Second)
+
+	rows, err := csv.NewReader(buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("expected header, probe and summary rows; actual %d rows", len(rows))
+	}
+	if rows[1][0] != "probe" || rows[1][5] != "1.000" {
+		t.Errorf("unexpected probe row %q", rows[1])
+	}
+	if rows[2][0] != "summary" || rows[2][8] != "1" {
+		t.Errorf("unexpected summary row %q", rows[2])
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ping probes every target count times (forever if count <= 0), starting a
+// new round every interval until ctx is done. At most workers probes are in
+// flight at once. The returned stats are in the same order as targets.
+func ping(ctx context.Context, targets []Target, count int, interval time.Duration, workers int, p Prober, rep Reporter) []*Stats {
+	stats := make([]*Stats, len(targets))
+	for i := range stats {
+		stats[i] = new(Stats)
+	}
+	probeLoop(ctx, targets, count, interval, workers, p, func(i int, r Result) {
+		stats[i].Add(r.RTT, r.Err)
+		rep.Probe(r)
+	})
+	return stats
+}
+
+// probeLoop schedules the probes of ping and calls report with the index of
+// the target and the result of every probe that was not cut short by ctx.
+// Stats keep every round trip time, so callers that probe forever without
+// printing a summary, such as the exporter, call probeLoop directly.
+func probeLoop(ctx context.Context, targets []Target, count int, interval time.Duration, workers int, p Prober, report func(i int, r Result)) {
+	type job struct {
+		i, seq int
+	}
+	type outcome struct {
+		i int
+		r Result
+	}
+	jobs := make(chan job)
+	results := make(chan outcome)
+
+	go func() {
+		defer close(jobs)
+		for seq := 1; count <= 0 || seq <= count; seq++ {
+			for i := range targets {
+				select {
+				case <-ctx.Done():
+					return
+				case jobs <- job{i: i, seq: seq}:
+				}
+			}
+			if seq == count {
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for range max(workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				now := time.Now()
+				r := p.Probe(ctx, targets[j.i])
+				r.Time, r.Target, r.Seq = now, targets[j.i].String(), j.seq
+				results <- outcome{i: j.i, r: r}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// report is only called from this goroutine.
+	for o := range results {
+		if ctx.Err() != nil {
+			// Probes cut short by Ctrl+C are not losses.
+			continue
+		}
+		report(o.i, o.r)
+	}
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"io"
+	"net"
 	"os"
 	"testing"
+	"time"
 )
 
 func init() {
@@ -15,4 +19,43 @@ func init() {
 }
 
 func TestPing(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	closed, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = closed.Close()
+
+	targets := []Target{{Name: l.Addr().String()}, {Name: closed.Addr().String()}}
+	stats := ping(context.Background(), targets, 3, time.Millisecond, 2, &tcpProber{timeout: time.Second}, &textReporter{w: io.Discard})
+
+	if s := stats[0]; s.Sent != 3 || s.Received != 3 {
+		t.Errorf("expected 3 sent and 3 received; actual %d and %d", s.Sent, s.Received)
+	}
+	if s := stats[1]; s.Sent != 3 || s.Received != 0 {
+		t.Errorf("expected 3 sent and 0 received; actual %d and %d", s.Sent, s.Received)
+	}
+}
+
+func TestPingCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	stats := ping(ctx, []Target{{Name: "127.0.0.1:1"}}, 0, time.Millisecond, 1, &tcpProber{timeout: time.Second}, &textReporter{w: io.Discard})
+	if stats[0].Sent != 0 {
+		t.Errorf("expected no probes after cancel; actual %d", stats[0].Sent)
+	}
 }
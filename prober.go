@@ -0,0 +1,34 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"net"
+	"time"
+)
+
+// Prober performs a single probe against target. It fills in the address,
+// timings and error of the Result; ping sets the remaining fields.
+type Prober interface {
+	Probe(ctx context.Context, target Target) Result
+}
+
+// tcpProber measures how long it takes to establish a TCP connection.
+type tcpProber struct {
+	timeout time.Duration
+	// network is tcp, tcp4 or tcp6; empty means tcp.
+	network  string
+	resolver *net.Resolver
+}
+
+func (p *tcpProber) Probe(ctx context.Context, target Target) Result {
+	d := net.Dialer{Timeout: p.timeout, Resolver: p.resolver}
+	start := time.Now()
+	conn, err := d.DialContext(ctx, cmp.Or(p.network, "tcp"), target.dialAddr())
+	rtt := time.Since(start)
+	if err != nil {
+		return Result{RTT: rtt, Err: err}
+	}
+	_ = conn.Close()
+	return Result{Addr: conn.RemoteAddr(), RTT: rtt}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/vfor4/gonet/tlv"
+)
+
+// ErrNoPong is returned when the server keeps the connection open but does
+// not answer a ping in time.
+var ErrNoPong = errors.New("no pong received")
+
+// appProber keeps one connection per target open and measures the round trip
+// of framed ping/pong messages over it, which includes the time the server
+// takes to process the ping and not just the TCP handshake.
+type appProber struct {
+	network  string
+	resolver *net.Resolver
+	timeout  time.Duration
+
+	mu    sync.Mutex
+	conns map[string]*appConn
+}
+
+type appConn struct {
+	mu   sync.Mutex
+	conn *tlv.FramedConn
+	// lost counts pings whose pong did not arrive in time; their pongs are
+	// skipped if they show up later so they are not credited to a newer ping.
+	lost int
+}
+
+func newAppProber(network string, resolver *net.Resolver, timeout time.Duration) *appProber {
+	return &appProber{network: network, resolver: resolver, timeout: timeout, conns: make(map[string]*appConn)}
+}
+
+func (p *appProber) Probe(ctx context.Context, target Target) Result {
+	p.mu.Lock()
+	c, ok := p.conns[target.String()]
+	if !ok {
+		c = new(appConn)
+		p.conns[target.String()] = c
+	}
+	p.mu.Unlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var r Result
+	if c.conn == nil {
+		d := net.Dialer{Timeout: p.timeout, Resolver: p.resolver}
+		start := time.Now()
+		conn, err := d.DialContext(ctx, cmp.Or(p.network, "tcp"), target.dialAddr())
+		r.Connect = time.Since(start)
+		if err != nil {
+			r.RTT, r.Err = r.Connect, err
+			return r
+		}
+		// Unversioned frames, which every TLV server understands.
+		c.conn = tlv.NewFramedConn(conn, tlv.Config{MaxPayloadSize: maxAppFrame, Legacy: true})
+		c.lost = 0
+	}
+	r.Addr = c.conn.RemoteAddr()
+
+	stop := context.AfterFunc(ctx, func() { _ = c.conn.SetDeadline(time.Now()) })
+	defer stop()
+	_ = c.conn.SetDeadline(time.Now().Add(p.timeout))
+	start := time.Now()
+	err := c.roundTrip()
+	r.RTT = time.Since(start)
+	switch {
+	case errors.Is(err, os.ErrDeadlineExceeded) && ctx.Err() == nil && c.conn.Err() == nil:
+		// The deadline hit between frames, so the connection can carry
+		// the next ping.
+		c.lost++
+		r.Err = fmt.Errorf("%w within %v, connection still open: %w", ErrNoPong, p.timeout, err)
+	case err != nil:
+		// The connection is broken; dial again on the next probe.
+		_ = c.conn.Close()
+		c.conn = nil
+		r.Err = err
+	}
+	return r
+}
+
+// roundTrip sends a ping and waits for its pong, answering any pings the
+// server sends in the meantime.
+func (c *appConn) roundTrip() error {
+	if err := c.conn.WritePayload(tlv.String("ping")); err != nil {
+		return err
+	}
+	for {
+		p, err := c.conn.ReadPayload()
+		if err != nil {
+			return err
+		}
+		msg, ok := p.(*tlv.String)
+		if !ok {
+			return fmt.Errorf("unexpected %T payload", p)
+		}
+		switch *msg {
+		case "pong":
+			if c.lost > 0 {
+				c.lost--
+				continue
+			}
+			return nil
+		case "ping":
+			if err := c.conn.WritePayload(tlv.String("pong")); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unexpected message %q", *msg)
+		}
+	}
+}
+
+// Close closes every connection kept open by the prober.
+func (p *appProber) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var errs []error
+	for _, c := range p.conns {
+		c.mu.Lock()
+		if c.conn != nil {
+			errs = append(errs, c.conn.Close())
+			c.conn = nil
+		}
+		c.mu.Unlock()
+	}
+	return errors.Join(errs...)
+}
+
+// maxAppFrame bounds the ping/pong frames we are willing to read, far below
+// tlv.MaxPayloadSize.
+const maxAppFrame = 1 << 10
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vfor4/gonet/tlv"
+)
+
+// pongServer answers every framed "ping" with a "pong", waiting for
+// release before answering while hold is set.
+func pongServer(t *testing.T, hold *atomic.Bool, release <-chan struct{}) (string, *atomic.Int32) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	accepted := new(atomic.Int32)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			go func() {
+				defer conn.Close()
+				for {
+					p, err := tlv.Decode(conn)
+					if err != nil {
+						return
+					}
+					if p.String() != "ping" {
+						continue
+					}
+					if hold.Load() {
+						<-release
+					}
+					if _, err := tlv.String("pong").WriteTo(conn); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return l.Addr().String(), accepted
+}
+
+func TestAppProber(t *testing.T) {
+	var hold atomic.Bool
+	addr, accepted := pongServer(t, &hold, nil)
+	p := newAppProber("tcp", nil, time.Second)
+	defer p.Close()
+
+	for i := range 3 {
+		r := p.Probe(context.Background(), Target{Name: addr})
+		if r.Err != nil {
+			t.Fatalf("%d: %v", i, r.Err)
+		}
+		if (i == 0) != (r.Connect > 0) {
+			t.Errorf("%d: expected connect time on the first probe only; actual %v", i, r.Connect)
+		}
+	}
+	if n := accepted.Load(); n != 1 {
+		t.Errorf("expected a single persistent connection; actual %d", n)
+	}
+}
+
+func TestAppProberUnresponsive(t *testing.T) {
+	var hold atomic.Bool
+	release := make(chan struct{})
+	addr, accepted := pongServer(t, &hold, release)
+	p := newAppProber("tcp", nil, 50*time.Millisecond)
+	defer p.Close()
+
+	if r := p.Probe(context.Background(), Target{Name: addr}); r.Err != nil {
+		t.Fatal(r.Err)
+	}
+	hold.Store(true)
+	r := p.Probe(context.Background(), Target{Name: addr})
+	if !errors.Is(r.Err, ErrNoPong) || errorClass(r.Err) != "timeout" {
+		t.Fatalf("expected a pong timeout; actual %v", r.Err)
+	}
+
+	// The late pong of the lost ping must not be credited to the next one.
+	hold.Store(false)
+	close(release)
+	if r := p.Probe(context.Background(), Target{Name: addr}); r.Err != nil {
+		t.Fatal(r.Err)
+	}
+	if r := p.Probe(context.Background(), Target{Name: addr}); r.Err != nil {
+		t.Fatal(r.Err)
+	}
+	if n := accepted.Load(); n != 1 {
+		t.Errorf("expected the connection to survive a missed pong; actual %d connections", n)
+	}
+}
+
+func TestAppProberMisaligned(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	var accepted atomic.Int32
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			first := accepted.Add(1) == 1
+			go func() {
+				defer conn.Close()
+				for {
+					if _, err := tlv.Decode(conn); err != nil {
+						return
+					}
+					var pong bytes.Buffer
+					_, _ = tlv.String("pong").WriteTo(&pong)
+					if first {
+						// The body of the pong straddles the prober's timeout.
+						first = false
+						_, _ = conn.Write(pong.Next(6))
+						time.Sleep(100 * time.Millisecond)
+					}
+					if _, err := conn.Write(pong.Bytes()); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	p := newAppProber("tcp", nil, 50*time.Millisecond)
+	defer p.Close()
+	if r := p.Probe(context.Background(), Target{Name: l.Addr().String()}); r.Err == nil || errors.Is(r.Err, ErrNoPong) {
+		t.Fatalf("expected a broken connection; actual %v", r.Err)
+	}
+	if r := p.Probe(context.Background(), Target{Name: l.Addr().String()}); r.Err != nil {
+		t.Fatalf("expected a new connection to answer; actual %v", r.Err)
+	}
+	if n := accepted.Load(); n != 2 {
+		t.Errorf("expected the prober to dial again; actual %d connections", n)
+	}
+}
+
+func TestAppProberAnswersServerPings(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	got := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, err := tlv.Decode(conn); err != nil {
+			return
+		}
+		// Heartbeat the client before answering its ping.
+		if _, err := tlv.String("ping").WriteTo(conn); err != nil {
+			return
+		}
+		p, err := tlv.Decode(conn)
+		if err != nil {
+			return
+		}
+		got <- p.String()
+		_, _ = tlv.String("pong").WriteTo(conn)
+	}()
+
+	p := newAppProber("tcp", nil, time.Second)
+	defer p.Close()
+	if r := p.Probe(context.Background(), Target{Name: l.Addr().String()}); r.Err != nil {
+		t.Fatal(r.Err)
+	}
+	if actual := <-got; actual != "pong" {
+		t.Errorf("expected the client to answer with pong; actual %q", actual)
+	}
+}
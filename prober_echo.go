@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// ErrEchoMismatch is returned when the bytes sent back by the peer differ
+// from the payload of the probe.
+var ErrEchoMismatch = errors.New("echoed payload does not match")
+
+// echoProber sends a payload to an echo service over udp, unix or unixgram
+// and times how long it takes for the same bytes to come back.
+type echoProber struct {
+	// network is udp, udp4, udp6, unix or unixgram.
+	network  string
+	resolver *net.Resolver
+	timeout  time.Duration
+	payload  []byte
+}
+
+// unixgramSeq keeps the local socket names of concurrent unixgram probes
+// apart.
+var unixgramSeq atomic.Uint64
+
+func (p *echoProber) Probe(ctx context.Context, target Target) Result {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	start := time.Now()
+	conn, err := p.dial(ctx, target.dialAddr())
+	connect := time.Since(start)
+	if err != nil {
+		return Result{RTT: connect, Err: err}
+	}
+	defer conn.Close()
+	r := Result{Addr: conn.RemoteAddr()}
+	if p.network == "unix" {
+		r.Connect = connect
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	// Unblock the read if ctx is canceled before the deadline.
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	start = time.Now()
+	if _, err = conn.Write(p.payload); err == nil {
+		err = p.readEcho(conn)
+	}
+	r.RTT = time.Since(start)
+	r.Err = err
+	return r
+}
+
+func (p *echoProber) dial(ctx context.Context, target string) (net.Conn, error) {
+	if p.network != "unixgram" {
+		d := net.Dialer{Resolver: p.resolver}
+		return d.DialContext(ctx, p.network, target)
+	}
+	// A unixgram client needs a bound address of its own to receive the
+	// echo on.
+	local := filepath.Join(os.TempDir(),
+		fmt.Sprintf("gonet-ping-%d-%d.sock", os.Getpid(), unixgramSeq.Add(1)))
+	conn, err := net.DialUnix(p.network,
+		&net.UnixAddr{Name: local, Net: p.network},
+		&net.UnixAddr{Name: target, Net: p.network})
+	if err != nil {
+		return nil, err
+	}
+	return &unlinkConn{UnixConn: conn, path: local}, nil
+}
+
+func (p *echoProber) readEcho(conn net.Conn) error {
+	buf := make([]byte, len(p.payload))
+	var err error
+	if p.network == "unix" {
+		// Streams may deliver the echo in several pieces.
+		_, err = io.ReadFull(conn, buf)
+	} else {
+		// One datagram in, one datagram out; read one extra byte so that a
+		// longer reply is not silently truncated into a match.
+		buf = make([]byte, len(p.payload)+1)
+		var n int
+		n, err = conn.Read(buf)
+		buf = buf[:n]
+	}
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(buf, p.payload) {
+		return fmt.Errorf("%w: sent %q, received %q", ErrEchoMismatch, p.payload, buf)
+	}
+	return nil
+}
+
+// unlinkConn removes the socket file of a bound unixgram client on Close.
+type unlinkConn struct {
+	*net.UnixConn
+	path string
+}
+
+func (c *unlinkConn) Close() error {
+	err := c.UnixConn.Close()
+	_ = os.Remove(c.path)
+	return err
+}
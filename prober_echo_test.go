@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEchoProberUDP(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	addr, err := echoServerUDP(ctx, "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &echoProber{network: "udp", timeout: time.Second, payload: []byte("ping")}
+	r := p.Probe(context.Background(), Target{Name: addr.String()})
+	if r.Err != nil {
+		t.Fatal(r.Err)
+	}
+	if r.RTT <= 0 || r.Addr.String() != addr.String() {
+		t.Errorf("unexpected result %+v", r)
+	}
+}
+
+func TestEchoProberUDPTimeout(t *testing.T) {
+	// A bound socket that never answers, so no ICMP unreachable comes back.
+	silent, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+
+	p := &echoProber{network: "udp", timeout: 50 * time.Millisecond, payload: []byte("ping")}
+	r := p.Probe(context.Background(), Target{Name: silent.LocalAddr().String()})
+	if actual := errorClass(r.Err); actual != "timeout" {
+		t.Errorf("expected timeout; actual %q (%v)", actual, r.Err)
+	}
+}
+
+func TestEchoProberMismatch(t *testing.T) {
+	l, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		b := make([]byte, 1024)
+		for {
+			_, addr, err := l.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			_, _ = l.WriteTo([]byte("pong"), addr)
+		}
+	}()
+
+	p := &echoProber{network: "udp", timeout: time.Second, payload: []byte("ping")}
+	r := p.Probe(context.Background(), Target{Name: l.LocalAddr().String()})
+	if !errors.Is(r.Err, ErrEchoMismatch) {
+		t.Errorf("expected ErrEchoMismatch; actual %v", r.Err)
+	}
+}
+
+func TestEchoProberUnix(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	socket := filepath.Join(t.TempDir(), "echo.sock")
+	addr, err := streamingEchoServer(ctx, "unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &echoProber{network: "unix", timeout: time.Second, payload: []byte("ping")}
+	r := p.Probe(context.Background(), Target{Name: addr.String()})
+	if r.Err != nil {
+		t.Fatal(r.Err)
+	}
+	if r.Connect <= 0 {
+		t.Error("expected connect time for unix streams")
+	}
+}
+
+func TestEchoProberUnixgram(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "echo.sock")
+	l, err := net.ListenPacket("unixgram", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		b := make([]byte, 1024)
+		for {
+			n, addr, err := l.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			_, _ = l.WriteTo(b[:n], addr)
+		}
+	}()
+
+	p := &echoProber{network: "unixgram", timeout: time.Second, payload: []byte("ping")}
+	r := p.Probe(context.Background(), Target{Name: socket})
+	if r.Err != nil {
+		t.Fatal(r.Err)
+	}
+	matches, _ := filepath.Glob(filepath.Join(os.TempDir(), "gonet-ping-*.sock"))
+	if len(matches) != 0 {
+		t.Errorf("expected client sockets to be removed; found %q", matches)
+	}
+}
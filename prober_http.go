@@ -0,0 +1,148 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// HTTPInfo is the timing breakdown of an HTTP probe. Connect and the TLS
+// handshake are reported through Result.Connect and Result.TLS.
+type HTTPInfo struct {
+	DNS      time.Duration
+	TTFB     time.Duration
+	Status   int
+	BodySize int64
+}
+
+// httpProber issues a request per probe over a fresh connection so that
+// every probe pays, and reports, the full DNS, connect and TLS cost.
+type httpProber struct {
+	client *http.Client
+	method string
+	expiry time.Duration
+	// network is tcp, tcp4 or tcp6; empty means tcp.
+	network  string
+	resolver *net.Resolver
+}
+
+func newHTTPProber(method string, timeout time.Duration, cfg *tls.Config, expiry time.Duration) *httpProber {
+	p := &httpProber{method: method, expiry: expiry}
+	tp := &http.Transport{
+		Proxy:             http.ProxyFromEnvironment,
+		DialContext:       p.dialContext,
+		TLSClientConfig:   cfg,
+		DisableKeepAlives: true,
+		ForceAttemptHTTP2: true,
+	}
+	p.client = &http.Client{
+		Transport: tp,
+		Timeout:   timeout,
+		// Following redirects would mix the timings of several requests.
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return p
+}
+
+func (p *httpProber) dialContext(ctx context.Context, _, addr string) (net.Conn, error) {
+	if pinned, ok := ctx.Value(pinnedAddrKey{}).(string); ok {
+		addr = pinned
+	}
+	d := net.Dialer{Resolver: p.resolver}
+	return d.DialContext(ctx, cmp.Or(p.network, "tcp"), addr)
+}
+
+// pinnedAddrKey carries Target.Addr from Probe to the transport's dialer.
+type pinnedAddrKey struct{}
+
+func (p *httpProber) Probe(ctx context.Context, target Target) Result {
+	if target.Addr != "" {
+		ctx = context.WithValue(ctx, pinnedAddrKey{}, target.Addr)
+	}
+	var (
+		mu                            sync.Mutex
+		r                             Result
+		info                          HTTPInfo
+		dnsStart, connStart, tlsStart time.Time
+		handshake                     time.Duration
+	)
+	start := time.Now()
+	// The transport may call hooks from several goroutines, e.g. when it
+	// races IPv4 and IPv6 connects.
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			mu.Lock()
+			dnsStart = time.Now()
+			mu.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			mu.Lock()
+			info.DNS = time.Since(dnsStart)
+			mu.Unlock()
+		},
+		ConnectStart: func(_, _ string) {
+			mu.Lock()
+			connStart = time.Now()
+			mu.Unlock()
+		},
+		ConnectDone: func(_, _ string, err error) {
+			mu.Lock()
+			if err == nil {
+				r.Connect = time.Since(connStart)
+			}
+			mu.Unlock()
+		},
+		TLSHandshakeStart: func() {
+			mu.Lock()
+			tlsStart = time.Now()
+			mu.Unlock()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			mu.Lock()
+			handshake = time.Since(tlsStart)
+			mu.Unlock()
+		},
+		GotConn: func(i httptrace.GotConnInfo) {
+			mu.Lock()
+			r.Addr = i.Conn.RemoteAddr()
+			mu.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			mu.Lock()
+			info.TTFB = time.Since(start)
+			mu.Unlock()
+		},
+	}
+
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), p.method, target.Name, nil)
+	if err != nil {
+		return Result{Err: err}
+	}
+	resp, err := p.client.Do(req)
+	if err == nil {
+		info.Status = resp.StatusCode
+		info.BodySize, err = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	r.RTT = time.Since(start)
+	r.Err = err
+	if err != nil {
+		return r
+	}
+	r.HTTP = &info
+	if resp.TLS != nil {
+		r.TLS = newTLSInfo(*resp.TLS, handshake, p.expiry)
+	}
+	return r
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHTTPProber(t *testing.T) {
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte("hello"))
+	}))
+	defer s.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(s.Certificate())
+	p := newHTTPProber(http.MethodGet, time.Second, &tls.Config{RootCAs: roots}, time.Hour)
+
+	r := p.Probe(context.Background(), Target{Name: s.URL})
+	if r.Err != nil {
+		t.Fatal(r.Err)
+	}
+	if r.HTTP == nil || r.TLS == nil {
+		t.Fatal("expected HTTP and TLS details")
+	}
+	if r.HTTP.Status != http.StatusAccepted || r.HTTP.BodySize != 5 {
+		t.Errorf("expected status 202 and 5 bytes; actual %d and %d", r.HTTP.Status, r.HTTP.BodySize)
+	}
+	if r.Addr == nil || r.Addr.String() != s.Listener.Addr().String() {
+		t.Errorf("expected addr %v; actual %v", s.Listener.Addr(), r.Addr)
+	}
+	if r.Connect <= 0 || r.TLS.Handshake <= 0 {
+		t.Errorf("expected connect and handshake timings; actual %v and %v", r.Connect, r.TLS.Handshake)
+	}
+	if r.HTTP.TTFB < 10*time.Millisecond || r.RTT < r.HTTP.TTFB {
+		t.Errorf("unexpected ttfb %v and total %v", r.HTTP.TTFB, r.RTT)
+	}
+}
+
+func TestHTTPProberNoRedirect(t *testing.T) {
+	s := httptest.NewServer(http.RedirectHandler("/elsewhere", http.StatusFound))
+	defer s.Close()
+
+	p := newHTTPProber(http.MethodHead, time.Second, nil, 0)
+	r := p.Probe(context.Background(), Target{Name: s.URL})
+	if r.Err != nil {
+		t.Fatal(r.Err)
+	}
+	if r.HTTP.Status != http.StatusFound {
+		t.Errorf("expected status 302; actual %d", r.HTTP.Status)
+	}
+	if r.TLS != nil {
+		t.Error("expected no TLS details for plain HTTP")
+	}
+}
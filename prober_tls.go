@@ -0,0 +1,86 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+// TLSInfo describes the TLS session negotiated by a probe.
+type TLSInfo struct {
+	Handshake   time.Duration
+	Version     string
+	CipherSuite string
+	ALPN        string
+	Subject     string
+	NotAfter    time.Time
+	ExpiresSoon bool
+}
+
+// tlsProber connects over TCP and then performs a TLS handshake, timing the
+// two steps separately.
+type tlsProber struct {
+	timeout time.Duration
+	// network is tcp, tcp4 or tcp6; empty means tcp.
+	network  string
+	resolver *net.Resolver
+	config   *tls.Config
+	// expiry is the window before the leaf certificate's NotAfter in which
+	// the probe flags the certificate as expiring soon.
+	expiry time.Duration
+}
+
+func (p *tlsProber) Probe(ctx context.Context, target Target) Result {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	d := net.Dialer{Resolver: p.resolver}
+	start := time.Now()
+	conn, err := d.DialContext(ctx, cmp.Or(p.network, "tcp"), target.dialAddr())
+	connect := time.Since(start)
+	if err != nil {
+		return Result{RTT: connect, Connect: connect, Err: err}
+	}
+	defer conn.Close()
+
+	cfg := p.config.Clone()
+	if cfg.ServerName == "" {
+		// Verify against the name the user asked for, not the pinned IP.
+		host, _, err := net.SplitHostPort(target.Name)
+		if err != nil {
+			return Result{Addr: conn.RemoteAddr(), RTT: connect, Connect: connect, Err: err}
+		}
+		cfg.ServerName = host
+	}
+	tlsConn := tls.Client(conn, cfg)
+	hsStart := time.Now()
+	err = tlsConn.HandshakeContext(ctx)
+	handshake := time.Since(hsStart)
+	r := Result{Addr: conn.RemoteAddr(), RTT: connect + handshake, Connect: connect, Err: err}
+	if err != nil {
+		return r
+	}
+
+	r.TLS = newTLSInfo(tlsConn.ConnectionState(), handshake, p.expiry)
+	return r
+}
+
+// newTLSInfo summarizes state, flagging the leaf certificate when it expires
+// within the expiry window.
+func newTLSInfo(state tls.ConnectionState, handshake, expiry time.Duration) *TLSInfo {
+	info := &TLSInfo{
+		Handshake:   handshake,
+		Version:     tls.VersionName(state.Version),
+		CipherSuite: tls.CipherSuiteName(state.CipherSuite),
+		ALPN:        state.NegotiatedProtocol,
+	}
+	if len(state.PeerCertificates) > 0 {
+		leaf := state.PeerCertificates[0]
+		info.Subject = leaf.Subject.String()
+		info.NotAfter = leaf.NotAfter
+		info.ExpiresSoon = time.Until(leaf.NotAfter) < expiry
+	}
+	return info
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTLSProber(t *testing.T) {
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	s.EnableHTTP2 = true
+	s.StartTLS()
+	defer s.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(s.Certificate())
+	p := &tlsProber{
+		timeout: time.Second,
+		config: &tls.Config{
+			RootCAs:    roots,
+			NextProtos: []string{"h2", "http/1.1"},
+			MinVersion: tls.VersionTLS12,
+		},
+		expiry: 30 * 24 * time.Hour,
+	}
+
+	r := p.Probe(context.Background(), Target{Name: s.Listener.Addr().String()})
+	if r.Err != nil {
+		t.Fatal(r.Err)
+	}
+	if r.TLS == nil {
+		t.Fatal("expected TLS details")
+	}
+	if r.Connect <= 0 || r.TLS.Handshake <= 0 || r.RTT < r.Connect+r.TLS.Handshake {
+		t.Errorf("unexpected timings: connect %v, handshake %v, total %v", r.Connect, r.TLS.Handshake, r.RTT)
+	}
+	if r.TLS.ALPN != "h2" {
+		t.Errorf("expected ALPN h2; actual %q", r.TLS.ALPN)
+	}
+	if r.TLS.Version != "TLS 1.3" {
+		t.Errorf("expected TLS 1.3; actual %q", r.TLS.Version)
+	}
+	if !r.TLS.NotAfter.Equal(s.Certificate().NotAfter) {
+		t.Errorf("expected expiry %v; actual %v", s.Certificate().NotAfter, r.TLS.NotAfter)
+	}
+	if r.TLS.ExpiresSoon {
+		t.Error("test certificate should not expire within 30 days")
+	}
+
+	p.expiry = time.Until(s.Certificate().NotAfter) + time.Hour
+	if r := p.Probe(context.Background(), Target{Name: s.Listener.Addr().String()}); r.Err != nil || !r.TLS.ExpiresSoon {
+		t.Errorf("expected expiry warning; actual %+v", r)
+	}
+}
+
+func TestTLSProberUntrusted(t *testing.T) {
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer s.Close()
+
+	p := &tlsProber{timeout: time.Second, config: &tls.Config{}}
+	r := p.Probe(context.Background(), Target{Name: s.Listener.Addr().String()})
+	if actual := errorClass(r.Err); actual != "certificate" {
+		t.Errorf("expected certificate error; actual %q (%v)", actual, r.Err)
+	}
+	if r.Connect <= 0 {
+		t.Error("expected connect time even when the handshake fails")
+	}
+}
@@ -2,98 +2,18 @@ package main
 
 import (
 	"context"
-	"encoding/binary"
-	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net"
 	"os"
-	"strings"
 	"sync"
 	"testing"
 	"time"
-)
-
-const (
-	BinaryType uint8 = iota + 1
-	StringType
 
-	MaxPayloadSize uint32 = 10 << 20
+	"github.com/vfor4/gonet/tlv"
+	"github.com/vfor4/gonet/tlv/proxy"
 )
 
-var ErrMaxPayLoadSize = errors.New("maximum payload size exceeded")
-
-type Payload interface {
-	fmt.Stringer
-	io.ReaderFrom
-	io.WriterTo
-	Bytes() []byte
-}
-
-type String string
-
-func (s String) String() string {
-	return string(s)
-}
-
-func (s String) Bytes() []byte {
-	return []byte(s)
-}
-
-func (s *String) ReadFrom(r io.Reader) (int64, error) {
-	var l uint32
-	err := binary.Read(r, binary.BigEndian, &l)
-	if err != nil {
-		return 1, err
-	}
-	if l > MaxPayloadSize {
-		return 5, fmt.Errorf("%v exceeded MaxPayloadSize (10mb)", l)
-	}
-	payload := make([]byte, l)
-	n, err := r.Read(payload)
-	if err != nil {
-		return int64(n), fmt.Errorf("Failed to read payload, bytes is read: %v", n)
-	}
-	*s = String(payload)
-	n = +5
-	return int64(n), nil
-}
-
-func (s String) WriteTo(w io.Writer) (int64, error) {
-	err := binary.Write(w, binary.BigEndian, StringType)
-	if err != nil {
-		return 0, err
-	}
-	err = binary.Write(w, binary.BigEndian, uint32(len(s)))
-	if err != nil {
-		return 1, err
-	}
-	n, err := w.Write([]byte(s))
-	n = +5
-	if err != nil {
-		return int64(n), err
-	}
-	return int64(0), nil
-}
-
-func decode(r io.Reader) (Payload, error) {
-	var t uint8
-	err := binary.Read(r, binary.BigEndian, &t)
-	if err != nil {
-		return nil, err
-	}
-	var payload Payload
-	switch t {
-	case StringType:
-		payload = new(String)
-	default:
-		panic(fmt.Sprintf("Not supported type %s(%v)", string(t), t))
-	}
-	_, err = payload.ReadFrom(r)
-	return payload, nil
-}
-
 func xTestProxy(t *testing.T) {
 	var wg sync.WaitGroup
 	proxyAddr := "127.0.0.1:38027"
@@ -122,15 +42,16 @@ func StartClient(addr string, wg *sync.WaitGroup) error {
 			cancel()
 			wg.Done()
 		}()
-		p := String("ping")
+		p := tlv.String("ping")
 		_, err = p.WriteTo(conn)
 		if err != nil {
 			fmt.Printf("@5, %v\n", err)
 			return
 		}
 		go func() {
+			defer conn.Close()
 			for {
-				p, err := decode(conn)
+				p, err := tlv.Decode(conn)
 				if err != nil {
 					fmt.Printf("@2, %v", err)
 					return
@@ -139,7 +60,7 @@ func StartClient(addr string, wg *sync.WaitGroup) error {
 				case "pong":
 					fmt.Println("shut down client")
 					return
-					// po := String("end")
+					// po := tlv.String("end")
 					// _, err := po.WriteTo(conn)
 					// if err != nil {
 					// 	fmt.Printf("@8, %v\n", err)
@@ -177,7 +98,7 @@ func StartListener(addr string, ready chan struct{}, wg *sync.WaitGroup) error {
 			wg.Done()
 		}()
 		for {
-			p, err := decode(conn)
+			p, err := tlv.Decode(conn)
 			if err != nil {
 				fmt.Printf("@2, %v", err)
 				return
@@ -185,7 +106,7 @@ func StartListener(addr string, ready chan struct{}, wg *sync.WaitGroup) error {
 			switch {
 			case p.String() == "ping":
 				fmt.Println("pong")
-				po := String("pong")
+				po := tlv.String("pong")
 				_, err := po.WriteTo(conn)
 				if err != nil {
 					fmt.Printf("@8, %v\n", err)
@@ -193,12 +114,9 @@ func StartListener(addr string, ready chan struct{}, wg *sync.WaitGroup) error {
 				}
 				fmt.Println("shut down listener")
 				return
-			case strings.Contains(p.String(), "proxy"):
-				fmt.Println(p.String(), "hi proxy")
-				return
 			// case "end":
 			// 	fmt.Println("ackend")
-			// 	po := String("ackend")
+			// 	po := tlv.String("ackend")
 			// 	_, err := po.WriteTo(conn)
 			// 	if err != nil {
 			// 		fmt.Printf("@9, %v\n", err)
@@ -233,13 +151,13 @@ func xTestWriteAndRead(t *testing.T) {
 		if err != nil {
 			fmt.Printf("Listener error %v\n", err)
 		}
-		p, err := decode(conn)
+		p, err := tlv.Decode(conn)
 		if err != nil {
 			fmt.Printf("Listener read error, %v", err)
 			return
 		}
 		if p.String() == "ping" {
-			po := String("pong")
+			po := tlv.String("pong")
 			_, err := po.WriteTo(conn)
 			if err != nil {
 				fmt.Printf("Failed to send pong, %v\n", err)
@@ -253,13 +171,13 @@ func xTestWriteAndRead(t *testing.T) {
 		fmt.Printf("Dial error %v\n", err)
 		return
 	}
-	p := String("ping")
+	p := tlv.String("ping")
 	_, err = p.WriteTo(dConn)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
-	po, err := decode(dConn)
+	po, err := tlv.Decode(dConn)
 	if err != nil {
 		fmt.Printf("Dial error to read pong %v\n", err)
 		return
@@ -274,33 +192,25 @@ func StartProxy(addr, serverAddr string, wg *sync.WaitGroup, ready chan struct{}
 	if err != nil {
 		fmt.Printf("@1, %v\n", err)
 	}
+	p := &proxy.Proxy{
+		Dial: func(ctx context.Context) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "tcp", serverAddr)
+		},
+		Filters: []proxy.Filter{proxy.Log(log.New(os.Stdout, "proxy ", 0))},
+		Client:  tlv.Config{Legacy: true},
+		Server:  tlv.Config{Legacy: true},
+	}
 	go func() {
+		defer wg.Done()
 		from, err := l.Accept()
 		if err != nil {
 			fmt.Printf("@2 error %v\n", err)
 			return
 		}
-		defer func() {
-			from.Close()
-			wg.Done()
-		}()
-		to, err := net.Dial("tcp", serverAddr)
-		if err != nil {
+		if err := p.ServeConn(context.Background(), from); err != nil {
 			fmt.Printf("@3 error %v\n", err)
-			return
-		}
-		defer func() {
-			to.Close()
-			wg.Done()
-		}()
-		r, w := io.Pipe()
-		p := String("proxy here")
-		_, err = p.WriteTo(w)
-		if err != nil {
-			fmt.Println(err)
-			return
 		}
-		io.Copy(to, r)
 	}()
 	ready <- struct{}{}
 }
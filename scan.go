@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// PortState is the classification of a scanned port.
+type PortState string
+
+const (
+	// PortOpen accepted the connection.
+	PortOpen PortState = "open"
+	// PortClosed answered with a RST.
+	PortClosed PortState = "closed"
+	// PortFiltered did not answer before the timeout, or an ICMP error said
+	// the port is unreachable.
+	PortFiltered PortState = "filtered"
+	// PortError could not be probed at all, e.g. the host did not resolve.
+	PortError PortState = "error"
+)
+
+// PortResult is the outcome of scanning a single port.
+type PortResult struct {
+	Port  int
+	State PortState
+	RTT   time.Duration
+	Err   error
+}
+
+// splitScanTarget splits host:ports where ports is a comma separated list of
+// ports and port ranges, e.g. example.com:22,80,8000-8100.
+func splitScanTarget(target string) (string, []int, error) {
+	i := strings.LastIndex(target, ":")
+	if i < 0 {
+		return "", nil, fmt.Errorf("%s: missing ports", target)
+	}
+	host := strings.TrimSuffix(strings.TrimPrefix(target[:i], "["), "]")
+	ports, err := parsePorts(target[i+1:])
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %w", target, err)
+	}
+	return host, ports, nil
+}
+
+// parsePorts expands spec into a sorted list of unique ports.
+func parsePorts(spec string) ([]int, error) {
+	var ports []int
+	for _, part := range strings.Split(spec, ",") {
+		lo, hi, isRange := strings.Cut(part, "-")
+		first, err := parsePort(lo)
+		if err != nil {
+			return nil, err
+		}
+		last := first
+		if isRange {
+			if last, err = parsePort(hi); err != nil {
+				return nil, err
+			}
+			if last < first {
+				return nil, fmt.Errorf("invalid port range %q", part)
+			}
+		}
+		for p := first; p <= last; p++ {
+			ports = append(ports, p)
+		}
+	}
+	slices.Sort(ports)
+	return slices.Compact(ports), nil
+}
+
+func parsePort(s string) (int, error) {
+	p, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || p < 1 || p > 65535 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return p, nil
+}
+
+// resolveScanHost returns the address to scan host on: host itself if it is
+// an IP address, or else the first address it resolves to with r. Resolving
+// once spares a lookup per port, and keeps a name with several addresses
+// from splitting the timeout of every port between them. family is "", "4"
+// or "6".
+func resolveScanHost(ctx context.Context, r *net.Resolver, family, host string) (string, error) {
+	if _, err := netip.ParseAddr(host); err == nil {
+		return host, nil
+	}
+	ips, err := r.LookupIP(ctx, "ip"+family, host)
+	if err != nil {
+		return "", err
+	}
+	return ips[0].String(), nil
+}
+
+// classifyPort maps the error of a connect attempt to a PortState.
+func classifyPort(err error) PortState {
+	var (
+		netErr net.Error
+		dnsErr *net.DNSError
+	)
+	switch {
+	case err == nil:
+		return PortOpen
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return PortClosed
+	case errors.As(err, &dnsErr):
+		return PortError
+	case errors.As(err, &netErr) && netErr.Timeout(),
+		errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return PortFiltered
+	default:
+		return PortError
+	}
+}
+
+// scan connects to every port of host with at most workers concurrent
+// attempts and returns the results sorted by port.
+func scan(ctx context.Context, host string, ports []int, workers int, p *tcpProber) []PortResult {
+	jobs := make(chan int)
+	results := make([]PortResult, 0, len(ports))
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for range max(workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for port := range jobs {
+				r := p.Probe(ctx, Target{Name: net.JoinHostPort(host, strconv.Itoa(port))})
+				if ctx.Err() != nil {
+					continue
+				}
+				mu.Lock()
+				results = append(results, PortResult{Port: port, State: classifyPort(r.Err), RTT: r.RTT, Err: r.Err})
+				mu.Unlock()
+			}
+		}()
+	}
+feed:
+	for _, port := range ports {
+		select {
+		case <-ctx.Done():
+			break feed
+		case jobs <- port:
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	slices.SortFunc(results, func(a, b PortResult) int { return a.Port - b.Port })
+	return results
+}
+
+// writeScanReport renders the results of scanning host on addr in the given
+// -output mode.
+func writeScanReport(w io.Writer, mode, host, addr string, results []PortResult) error {
+	counts := make(map[PortState]int)
+	for _, r := range results {
+		counts[r.State]++
+	}
+	switch mode {
+	case "text":
+		if addr != host {
+			fmt.Fprintf(w, "scan report for %s (%s)\n", host, addr)
+		} else {
+			fmt.Fprintf(w, "scan report for %s\n", host)
+		}
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "PORT\tSTATE\tTIME")
+		for _, r := range results {
+			if r.State == PortClosed {
+				continue
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%v\n", r.Port, r.State, r.RTT.Round(time.Microsecond))
+		}
+		_ = tw.Flush()
+		fmt.Fprintf(w, "%d ports scanned: %d open, %d closed, %d filtered, %d errors\n", len(results),
+			counts[PortOpen], counts[PortClosed], counts[PortFiltered], counts[PortError])
+	case "json":
+		type record struct {
+			Type   string            `json:"type"`
+			Host   string            `json:"host"`
+			Addr   string            `json:"addr"`
+			Port   int               `json:"port,omitempty"`
+			State  PortState         `json:"state,omitempty"`
+			RTTMs  float64           `json:"rtt_ms,omitempty"`
+			Error  string            `json:"error,omitempty"`
+			Counts map[PortState]int `json:"counts,omitempty"`
+		}
+		enc := json.NewEncoder(w)
+		for _, r := range results {
+			rec := record{Type: "port", Host: host, Addr: addr, Port: r.Port, State: r.State, RTTMs: ms(r.RTT)}
+			if r.Err != nil {
+				rec.Error = r.Err.Error()
+			}
+			if err := enc.Encode(rec); err != nil {
+				return err
+			}
+		}
+		return enc.Encode(record{Type: "summary", Host: host, Addr: addr, Counts: counts})
+	case "csv":
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{"host", "addr", "port", "state", "rtt_ms", "error"})
+		for _, r := range results {
+			var errStr string
+			if r.Err != nil {
+				errStr = r.Err.Error()
+			}
+			_ = cw.Write([]string{host, addr, strconv.Itoa(r.Port), string(r.State), formatFloat(ms(r.RTT)), errStr})
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return fmt.Errorf("unknown output mode %q", mode)
+	}
+	return nil
+}
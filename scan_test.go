@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParsePorts(t *testing.T) {
+	tests := []struct {
+		spec     string
+		expected []int
+		err      bool
+	}{
+		{"80", []int{80}, false},
+		{"443,80,22", []int{22, 80, 443}, false},
+		{"8000-8003,8001,22", []int{22, 8000, 8001, 8002, 8003}, false},
+		{"10-5", nil, true},
+		{"0", nil, true},
+		{"http", nil, true},
+		{"1-70000", nil, true},
+	}
+	for _, c := range tests {
+		actual, err := parsePorts(c.spec)
+		if (err != nil) != c.err {
+			t.Errorf("%q: expected error %v; actual %v", c.spec, c.err, err)
+			continue
+		}
+		if !slices.Equal(actual, c.expected) {
+			t.Errorf("%q: expected %v; actual %v", c.spec, c.expected, actual)
+		}
+	}
+}
+
+func TestSplitScanTarget(t *testing.T) {
+	host, ports, err := splitScanTarget("[::1]:80,443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host != "::1" || !slices.Equal(ports, []int{80, 443}) {
+		t.Errorf("unexpected host %q and ports %v", host, ports)
+	}
+	if _, _, err := splitScanTarget("example.com"); err == nil {
+		t.Error("expected an error without ports")
+	}
+}
+
+func TestClassifyPort(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected PortState
+	}{
+		{nil, PortOpen},
+		{&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, PortClosed},
+		{&net.OpError{Op: "dial", Err: &timeoutError{}}, PortFiltered},
+		{&net.OpError{Op: "dial", Err: syscall.EHOSTUNREACH}, PortFiltered},
+		{&net.DNSError{Err: "no such host", Name: "nope.invalid"}, PortError},
+	}
+	for i, c := range tests {
+		if actual := classifyPort(c.err); actual != c.expected {
+			t.Errorf("%d: expected %q; actual %q", i, c.expected, actual)
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	var open []int
+	for range 2 {
+		l, err := net.Listen("tcp", "127.0.0.1:")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		open = append(open, l.Addr().(*net.TCPAddr).Port)
+	}
+	closed, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	_ = closed.Close()
+
+	ports := []int{open[1], closedPort, open[0]}
+	results := scan(context.Background(), "127.0.0.1", ports, 2, &tcpProber{timeout: time.Second})
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results; actual %d", len(results))
+	}
+	if !slices.IsSortedFunc(results, func(a, b PortResult) int { return a.Port - b.Port }) {
+		t.Errorf("expected results sorted by port; actual %v", results)
+	}
+	for _, r := range results {
+		expected := PortOpen
+		if r.Port == closedPort {
+			expected = PortClosed
+		}
+		if r.State != expected {
+			t.Errorf("port %d: expected %q; actual %q", r.Port, expected, r.State)
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	if err := writeScanReport(buf, "text", "127.0.0.1", "127.0.0.1", results); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), strconv.Itoa(closedPort)+" ") {
+		t.Errorf("closed ports should be left out of the table: %q", buf.String())
+	}
+	if !strings.Contains(buf.String(), "3 ports scanned: 2 open, 1 closed") {
+		t.Errorf("unexpected report %q", buf.String())
+	}
+}
+
+func TestResolveScanHost(t *testing.T) {
+	// An address is scanned as is, without a lookup.
+	broken := &net.Resolver{PreferGo: true, Dial: func(context.Context, string, string) (net.Conn, error) {
+		return nil, errors.New("no lookups expected")
+	}}
+	for _, host := range []string{"192.0.2.1", "2001:db8::1"} {
+		if addr, err := resolveScanHost(context.Background(), broken, "", host); err != nil || addr != host {
+			t.Errorf("%s: expected the address itself; actual %q, %v", host, addr, err)
+		}
+	}
+	if addr, err := resolveScanHost(context.Background(), net.DefaultResolver, "4", "localhost"); err != nil || addr != "127.0.0.1" {
+		t.Errorf("expected localhost to resolve to 127.0.0.1; actual %q, %v", addr, err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := writeScanReport(buf, "text", "localhost", "127.0.0.1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "scan report for localhost (127.0.0.1)\n") {
+		t.Errorf("expected the report to name the address scanned; actual %q", buf.String())
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"time"
+)
+
+// Stats accumulates the outcome of every probe sent to a single target.
+type Stats struct {
+	Sent     int
+	Received int
+	rtts     []time.Duration
+}
+
+// Add records a probe. A non-nil err counts the probe as lost.
+func (s *Stats) Add(rtt time.Duration, err error) {
+	s.Sent++
+	if err != nil {
+		return
+	}
+	s.Received++
+	s.rtts = append(s.rtts, rtt)
+}
+
+// Loss returns the percentage of probes that did not succeed.
+func (s *Stats) Loss() float64 {
+	if s.Sent == 0 {
+		return 0
+	}
+	return float64(s.Sent-s.Received) / float64(s.Sent) * 100
+}
+
+func (s *Stats) Min() time.Duration {
+	if len(s.rtts) == 0 {
+		return 0
+	}
+	return slices.Min(s.rtts)
+}
+
+func (s *Stats) Max() time.Duration {
+	if len(s.rtts) == 0 {
+		return 0
+	}
+	return slices.Max(s.rtts)
+}
+
+func (s *Stats) Avg() time.Duration {
+	if len(s.rtts) == 0 {
+		return 0
+	}
+	var sum time.Duration
+	for _, rtt := range s.rtts {
+		sum += rtt
+	}
+	return sum / time.Duration(len(s.rtts))
+}
+
+// Mdev is the standard deviation of the round trip times, computed the same
+// way ping(8) does: sqrt(mean(rtt²) - mean(rtt)²).
+func (s *Stats) Mdev() time.Duration {
+	if len(s.rtts) == 0 {
+		return 0
+	}
+	var sum, sum2 float64
+	for _, rtt := range s.rtts {
+		v := float64(rtt)
+		sum += v
+		sum2 += v * v
+	}
+	n := float64(len(s.rtts))
+	avg := sum / n
+	return time.Duration(math.Sqrt(math.Max(sum2/n-avg*avg, 0)))
+}
+
+// Percentile returns the nearest-rank p-th percentile (0 < p <= 100) of the
+// successful round trip times.
+func (s *Stats) Percentile(p float64) time.Duration {
+	if len(s.rtts) == 0 {
+		return 0
+	}
+	sorted := slices.Clone(s.rtts)
+	slices.Sort(sorted)
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	rank = min(max(rank, 1), len(sorted))
+	return sorted[rank-1]
+}
+
+// Summary writes a ping(8) style report of the collected statistics.
+func (s *Stats) Summary(w io.Writer, target string, elapsed time.Duration) {
+	fmt.Fprintf(w, "\n--- %s ping statistics ---\n", target)
+	fmt.Fprintf(w, "%d probes transmitted, %d received, %.1f%% loss, time %v\n",
+		s.Sent, s.Received, s.Loss(), elapsed.Round(time.Millisecond))
+	if s.Received == 0 {
+		return
+	}
+	fmt.Fprintf(w, "rtt min/avg/max/mdev = %.3f/%.3f/%.3f/%.3f ms\n",
+		ms(s.Min()), ms(s.Avg()), ms(s.Max()), ms(s.Mdev()))
+	fmt.Fprintf(w, "rtt p50/p90/p99 = %.3f/%.3f/%.3f ms\n",
+		ms(s.Percentile(50)), ms(s.Percentile(90)), ms(s.Percentile(99)))
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	s := new(Stats)
+	for _, v := range []int{10, 20, 30, 40} {
+		s.Add(time.Duration(v)*time.Millisecond, nil)
+	}
+	s.Add(0, errors.New("lost"))
+
+	if s.Sent != 5 || s.Received != 4 {
+		t.Fatalf("expected 5 sent and 4 received; actual %d and %d", s.Sent, s.Received)
+	}
+	if actual := s.Loss(); actual != 20 {
+		t.Errorf("expected 20%% loss; actual %v", actual)
+	}
+	tests := []struct {
+		name     string
+		actual   time.Duration
+		expected time.Duration
+	}{
+		{"min", s.Min(), 10 * time.Millisecond},
+		{"max", s.Max(), 40 * time.Millisecond},
+		{"avg", s.Avg(), 25 * time.Millisecond},
+		{"mdev", s.Mdev().Round(time.Microsecond), 11180 * time.Microsecond},
+		{"p50", s.Percentile(50), 20 * time.Millisecond},
+		{"p90", s.Percentile(90), 40 * time.Millisecond},
+	}
+	for _, c := range tests {
+		if c.actual != c.expected {
+			t.Errorf("%s: expected %v; actual %v", c.name, c.expected, c.actual)
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	s.Summary(buf, "test:80", time.Second)
+	if !strings.Contains(buf.String(), "5 probes transmitted, 4 received, 20.0% loss") {
+		t.Errorf("unexpected summary %q", buf.String())
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// tableReporter redraws a per-target table on every probe. It is meant for
+// interactive terminals; use the line based reporters everywhere else.
+type tableReporter struct {
+	w       io.Writer
+	targets []string
+	rows    map[string]*tableRow
+	begin   time.Time
+}
+
+type tableRow struct {
+	stats Stats
+	last  Result
+}
+
+func NewTableReporter(w io.Writer, targets []string) Reporter {
+	rows := make(map[string]*tableRow, len(targets))
+	for _, t := range targets {
+		rows[t] = new(tableRow)
+	}
+	return &tableReporter{w: w, targets: targets, rows: rows, begin: time.Now()}
+}
+
+func (t *tableReporter) Probe(r Result) {
+	row, ok := t.rows[r.Target]
+	if !ok {
+		return
+	}
+	row.stats.Add(r.RTT, r.Err)
+	row.last = r
+	t.render()
+}
+
+func (t *tableReporter) render() {
+	// Move the cursor home and clear the screen before redrawing.
+	fmt.Fprint(t.w, "\x1b[H\x1b[2J")
+	fmt.Fprintf(t.w, "%d targets, %v elapsed\n\n", len(t.targets), time.Since(t.begin).Round(time.Second))
+	tw := tabwriter.NewWriter(t.w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TARGET\tADDR\tSENT\tRECV\tLOSS\tLAST\tAVG\tERROR")
+	for _, target := range t.targets {
+		row := t.rows[target]
+		var addr, last, avg, errClass string
+		if row.last.Addr != nil {
+			addr = row.last.Addr.String()
+		}
+		if row.stats.Sent > 0 {
+			last = "-"
+			if row.last.Err == nil {
+				last = fmt.Sprintf("%.3fms", ms(row.last.RTT))
+			}
+			errClass = errorClass(row.last.Err)
+		}
+		if row.stats.Received > 0 {
+			avg = fmt.Sprintf("%.3fms", ms(row.stats.Avg()))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%.1f%%\t%s\t%s\t%s\n",
+			target, addr, row.stats.Sent, row.stats.Received, row.stats.Loss(), last, avg, errClass)
+	}
+	_ = tw.Flush()
+}
+
+func (t *tableReporter) Summary(target string, s *Stats, elapsed time.Duration) {
+	s.Summary(t.w, target, elapsed)
+}
+
+// isTerminal reports whether f is attached to a terminal rather than a pipe
+// or a regular file.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestTableReporter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	rep := NewTableReporter(buf, []string{"a:80", "b:80"})
+	addr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 80}
+	rep.Probe(Result{Target: "a:80", Addr: addr, Seq: 1, RTT: 2 * time.Millisecond})
+	rep.Probe(Result{Target: "a:80", Addr: addr, Seq: 2, RTT: 4 * time.Millisecond})
+	rep.Probe(Result{Target: "b:80", Seq: 1, Err: syscall.ECONNREFUSED})
+	rep.Probe(Result{Target: "unknown:80", Seq: 1, RTT: time.Millisecond})
+
+	// Every probe redraws the whole table; only the last frame matters.
+	frames := strings.Split(buf.String(), "\x1b[H\x1b[2J")
+	if len(frames) != 4 {
+		t.Fatalf("expected 3 redraws; actual %d", len(frames)-1)
+	}
+	rows := map[string][]string{}
+	for _, line := range strings.Split(frames[len(frames)-1], "\n")[2:] {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			rows[fields[0]] = fields
+		}
+	}
+	if len(rows) != 3 {
+		t.Fatalf("expected a header and 2 target rows; actual %q", frames[len(frames)-1])
+	}
+
+	tests := []struct {
+		target   string
+		expected []string
+	}{
+		{"a:80", []string{"a:80", "127.0.0.1:80", "2", "2", "0.0%", "4.000ms", "3.000ms"}},
+		{"b:80", []string{"b:80", "1", "0", "100.0%", "-", "refused"}},
+	}
+	for _, c := range tests {
+		if actual := rows[c.target]; strings.Join(actual, " ") != strings.Join(c.expected, " ") {
+			t.Errorf("%s: expected row %q; actual %q", c.target, c.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Target is a probe destination. Name is what the user asked for: a
+// host:port, a URL or a socket path. Addr, when set, pins the probe to one
+// resolved ip:port of Name.
+type Target struct {
+	Name string
+	Addr string
+}
+
+func (t Target) String() string {
+	if t.Addr == "" {
+		return t.Name
+	}
+	host, _, err := net.SplitHostPort(t.Addr)
+	if err != nil {
+		host = t.Addr
+	}
+	return fmt.Sprintf("%s (%s)", t.Name, host)
+}
+
+// dialAddr is the address a prober should connect to.
+func (t Target) dialAddr() string {
+	if t.Addr != "" {
+		return t.Addr
+	}
+	return t.Name
+}
+
+// loadTargets merges the targets given as arguments with the ones listed in
+// file, skipping blank lines, # comments and duplicates.
+func loadTargets(args []string, file string) ([]Target, error) {
+	targets := make([]Target, 0, len(args))
+	seen := make(map[string]struct{})
+	add := func(t string) {
+		if _, ok := seen[t]; ok {
+			return
+		}
+		seen[t] = struct{}{}
+		targets = append(targets, Target{Name: t})
+	}
+	for _, a := range args {
+		add(a)
+	}
+	if file == "" {
+		return targets, nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		add(line)
+	}
+	return targets, s.Err()
+}
+
+// newResolver returns a resolver that sends every DNS query to addr, or the
+// system resolver when addr is empty.
+func newResolver(addr string, timeout time.Duration) *net.Resolver {
+	if addr == "" {
+		return net.DefaultResolver
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			d := net.Dialer{Timeout: timeout}
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+}
+
+// resolveTargets replaces every target with one target per address its host
+// resolves to, so that each address behind a name is probed on its own.
+// family is "", "4" or "6".
+func resolveTargets(ctx context.Context, r *net.Resolver, family string, targets []Target) ([]Target, error) {
+	resolved := make([]Target, 0, len(targets))
+	for _, t := range targets {
+		host, port, err := splitTarget(t.Name)
+		if err != nil {
+			return nil, err
+		}
+		ips, err := r.LookupIP(ctx, "ip"+family, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			resolved = append(resolved, Target{Name: t.Name, Addr: net.JoinHostPort(ip.String(), port)})
+		}
+	}
+	return resolved, nil
+}
+
+// splitTarget returns the host and port of a host:port or URL target.
+func splitTarget(name string) (host, port string, err error) {
+	if !strings.Contains(name, "://") {
+		return net.SplitHostPort(name)
+	}
+	u, err := url.Parse(name)
+	if err != nil {
+		return "", "", err
+	}
+	port = u.Port()
+	if port == "" {
+		switch u.Scheme {
+		case "https":
+			port = "443"
+		case "http":
+			port = "80"
+		default:
+			return "", "", fmt.Errorf("%s: unknown port for scheme %q", name, u.Scheme)
+		}
+	}
+	return u.Hostname(), port, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestLoadTargets(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "targets")
+	err := os.WriteFile(f, []byte("# web\nexample.com:443\n\nexample.com:80\nexample.org:22\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets, err := loadTargets([]string{"example.com:80"}, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Target{{Name: "example.com:80"}, {Name: "example.com:443"}, {Name: "example.org:22"}}
+	if !slices.Equal(targets, expected) {
+		t.Errorf("expected %q; actual %q", expected, targets)
+	}
+}
+
+// dnsStandIn answers A queries for every name in records over UDP and
+// NXDOMAIN for everything else.
+func dnsStandIn(t *testing.T, records map[string][]net.IP) string {
+	t.Helper()
+	l, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		b := make([]byte, 512)
+		for {
+			n, addr, err := l.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			var req dnsmessage.Message
+			if err := req.Unpack(b[:n]); err != nil || len(req.Questions) == 0 {
+				continue
+			}
+			q := req.Questions[0]
+			resp := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: req.ID, Response: true, Authoritative: true},
+				Questions: req.Questions,
+			}
+			ips, ok := records[q.Name.String()]
+			if !ok {
+				resp.RCode = dnsmessage.RCodeNameError
+			}
+			for _, ip := range ips {
+				if ip4 := ip.To4(); ip4 != nil && q.Type == dnsmessage.TypeA {
+					resp.Answers = append(resp.Answers, dnsmessage.Resource{
+						Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+						Body:   &dnsmessage.AResource{A: [4]byte(ip4)},
+					})
+				}
+			}
+			out, err := resp.Pack()
+			if err != nil {
+				continue
+			}
+			_, _ = l.WriteTo(out, addr)
+		}
+	}()
+	return l.LocalAddr().String()
+}
+
+func TestResolveTargets(t *testing.T) {
+	dns := dnsStandIn(t, map[string][]net.IP{
+		"backends.gonet.test.": {net.IPv4(127, 0, 0, 1), net.IPv4(127, 0, 0, 2)},
+	})
+	r := newResolver(dns, time.Second)
+
+	targets, err := resolveTargets(context.Background(), r, "4", []Target{
+		{Name: "backends.gonet.test:8080"},
+		{Name: "https://backends.gonet.test/health"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Target{
+		{Name: "backends.gonet.test:8080", Addr: "127.0.0.1:8080"},
+		{Name: "backends.gonet.test:8080", Addr: "127.0.0.2:8080"},
+		{Name: "https://backends.gonet.test/health", Addr: "127.0.0.1:443"},
+		{Name: "https://backends.gonet.test/health", Addr: "127.0.0.2:443"},
+	}
+	if !slices.Equal(targets, expected) {
+		t.Errorf("expected %v; actual %v", expected, targets)
+	}
+	if actual := targets[1].String(); actual != "backends.gonet.test:8080 (127.0.0.2)" {
+		t.Errorf("unexpected label %q", actual)
+	}
+
+	if _, err := resolveTargets(context.Background(), r, "4", []Target{{Name: "missing.gonet.test:80"}}); err == nil {
+		t.Error("expected an error for an unknown name")
+	}
+}
+
+func TestPingEveryAddress(t *testing.T) {
+	// Only 127.0.0.1 listens, so the second address behind the name is dead.
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	dns := dnsStandIn(t, map[string][]net.IP{
+		"backends.gonet.test.": {net.IPv4(127, 0, 0, 1), net.IPv4(127, 0, 0, 2)},
+	})
+	targets, err := resolveTargets(context.Background(), newResolver(dns, time.Second), "4",
+		[]Target{{Name: net.JoinHostPort("backends.gonet.test", port)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := ping(context.Background(), targets, 2, time.Millisecond, 2,
+		&tcpProber{timeout: time.Second, network: "tcp4"}, &textReporter{w: io.Discard})
+	if stats[0].Received != 2 || stats[1].Received != 0 {
+		t.Errorf("expected only the first address to answer; actual %d and %d received",
+			stats[0].Received, stats[1].Received)
+	}
+}
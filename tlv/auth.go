@@ -0,0 +1,337 @@
+package tlv
+
+import (
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/vfor4/gonet/netx"
+)
+
+// AuthType is the type of the Auth payload.
+const AuthType = ReservedType + 4
+
+// Auth handshake steps.
+const (
+	AuthChallenge uint8 = iota + 1
+	AuthResponse
+	AuthResult
+)
+
+const (
+	nonceSize = 32
+	// authContext is mixed into every MAC so that a key shared with
+	// another protocol cannot be abused to answer our challenges.
+	authContext = "gonet tlv auth v1"
+)
+
+const (
+	// DefaultMaxAuthFailures is used when Authenticator.MaxFailures is zero.
+	DefaultMaxAuthFailures = 5
+	// DefaultLockout is used when Authenticator.Lockout is zero.
+	DefaultLockout = time.Minute
+)
+
+var (
+	// ErrAuthFailed is returned when the peer could not prove it holds the
+	// key it claims.
+	ErrAuthFailed = errors.New("authentication failed")
+	// ErrLockedOut is returned by Accept for a peer that failed too often,
+	// and by Authenticate when the server refuses to challenge it.
+	ErrLockedOut = errors.New("too many authentication failures")
+)
+
+// dummyKey stands in for the key of an unknown key ID, so that answering for
+// one takes as long as for a known ID.
+var dummyKey = make([]byte, sha256.Size)
+
+// Auth carries a step of the challenge-response handshake of Authenticate
+// and Accept: the server challenges with a random nonce, the client answers
+// with the ID of a pre-shared key and the HMAC-SHA256 of the nonce under that
+// key, and the server tells whether it accepts.
+type Auth struct {
+	Step  uint8
+	Nonce []byte
+	KeyID string
+	MAC   []byte
+	OK    bool
+}
+
+func (a *Auth) String() string {
+	switch a.Step {
+	case AuthChallenge:
+		return "auth challenge"
+	case AuthResponse:
+		return fmt.Sprintf("auth response for key %q", a.KeyID)
+	case AuthResult:
+		return fmt.Sprintf("auth result ok=%t", a.OK)
+	default:
+		return fmt.Sprintf("auth step %d", a.Step)
+	}
+}
+
+func (a *Auth) Bytes() []byte {
+	b := []byte{a.Step}
+	switch a.Step {
+	case AuthChallenge:
+		b = append(b, a.Nonce...)
+	case AuthResponse:
+		b = append(b, byte(len(a.KeyID)))
+		b = append(b, a.KeyID...)
+		b = append(b, a.MAC...)
+	case AuthResult:
+		if a.OK {
+			b = append(b, 1)
+		} else {
+			b = append(b, 0)
+		}
+	}
+	return b
+}
+
+func (a *Auth) WriteTo(w io.Writer) (int64, error) {
+	if len(a.KeyID) > 255 {
+		return 0, fmt.Errorf("key ID of %d bytes is too long", len(a.KeyID))
+	}
+	return WriteFrame(w, AuthType, a.Bytes())
+}
+
+func (a *Auth) ReadFrom(r io.Reader) (int64, error) {
+	body, n, err := ReadBody(r)
+	if err != nil {
+		return n, err
+	}
+	bad := fmt.Errorf("malformed auth payload of %d bytes", len(body))
+	if len(body) == 0 {
+		return n, bad
+	}
+	a.Step, body = body[0], body[1:]
+	switch a.Step {
+	case AuthChallenge:
+		a.Nonce = body
+	case AuthResponse:
+		if len(body) == 0 || len(body) < 1+int(body[0]) {
+			return n, bad
+		}
+		a.KeyID = string(body[1 : 1+body[0]])
+		a.MAC = body[1+body[0]:]
+	case AuthResult:
+		if len(body) != 1 {
+			return n, bad
+		}
+		a.OK = body[0] == 1
+	default:
+		return n, bad
+	}
+	return n, nil
+}
+
+// authMAC returns the answer to nonce for the key keyID.
+func authMAC(key []byte, keyID string, nonce []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(authContext))
+	h.Write([]byte{byte(len(keyID))})
+	h.Write([]byte(keyID))
+	h.Write(nonce)
+	return h.Sum(nil)
+}
+
+// Authenticate performs the client side of the handshake, proving that it
+// holds key, known to the server as keyID.
+func Authenticate(ctx context.Context, c *FramedConn, keyID string, key []byte) error {
+	stop := netx.Bind(ctx, c.SetDeadline)
+	defer stop()
+	challenge, err := readAuth(c, AuthChallenge, AuthResult)
+	if err != nil {
+		return netx.ContextError(ctx, err)
+	}
+	if challenge.Step == AuthResult {
+		// A server only answers without a challenge when locked out.
+		return ErrLockedOut
+	}
+	if len(challenge.Nonce) != nonceSize {
+		return fmt.Errorf("challenge nonce of %d bytes", len(challenge.Nonce))
+	}
+	resp := &Auth{Step: AuthResponse, KeyID: keyID, MAC: authMAC(key, keyID, challenge.Nonce)}
+	if err := c.WritePayload(resp); err != nil {
+		return netx.ContextError(ctx, err)
+	}
+	result, err := readAuth(c, AuthResult)
+	if err != nil {
+		return netx.ContextError(ctx, err)
+	}
+	if !result.OK {
+		return ErrAuthFailed
+	}
+	return nil
+}
+
+// Authenticator is the server side of the handshake. It locks out the hosts
+// that fail it MaxFailures times in a row for the Lockout duration; failures
+// older than Lockout are forgotten. It is safe for concurrent use.
+type Authenticator struct {
+	// Keys returns the key with the given ID, and false if there is none.
+	Keys func(keyID string) ([]byte, bool)
+	// MaxFailures is how many failed handshakes lock a host out.
+	// DefaultMaxAuthFailures if zero.
+	MaxFailures int
+	// Lockout is how long a host stays locked out. DefaultLockout if zero.
+	Lockout time.Duration
+
+	mu       sync.Mutex
+	failures map[string]*authFailures
+	pruned   time.Time
+	now      func() time.Time
+}
+
+type authFailures struct {
+	count int
+	last  time.Time
+	until time.Time
+}
+
+type identityKey struct{}
+
+// Identity returns the key ID a connection authenticated with, from the
+// context returned by Accept.
+func Identity(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(identityKey{}).(string)
+	return id, ok
+}
+
+// Accept challenges the peer of c and returns ctx carrying its identity, see
+// Identity. It returns ErrAuthFailed if the peer does not answer the
+// challenge correctly and ErrLockedOut, without a challenge, if its host
+// failed too often recently. The caller closes c when Accept fails.
+func (a *Authenticator) Accept(ctx context.Context, c *FramedConn) (context.Context, error) {
+	stop := netx.Bind(ctx, c.SetDeadline)
+	defer stop()
+	host := hostOf(c.RemoteAddr())
+	if a.lockedOut(host) {
+		_ = c.WritePayload(&Auth{Step: AuthResult})
+		return ctx, ErrLockedOut
+	}
+
+	// A fresh random nonce per challenge is what keeps a recorded answer
+	// from being replayed.
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return ctx, err
+	}
+	if err := c.WritePayload(&Auth{Step: AuthChallenge, Nonce: nonce}); err != nil {
+		return ctx, netx.ContextError(ctx, err)
+	}
+	resp, err := readAuth(c, AuthResponse)
+	if err != nil {
+		return ctx, netx.ContextError(ctx, err)
+	}
+	key, known := a.Keys(resp.KeyID)
+	if !known {
+		key = dummyKey
+	}
+	valid := hmac.Equal(resp.MAC, authMAC(key, resp.KeyID, nonce))
+	if !known || !valid {
+		a.fail(host)
+		_ = c.WritePayload(&Auth{Step: AuthResult})
+		return ctx, ErrAuthFailed
+	}
+	a.succeed(host)
+	if err := c.WritePayload(&Auth{Step: AuthResult, OK: true}); err != nil {
+		return ctx, netx.ContextError(ctx, err)
+	}
+	return context.WithValue(ctx, identityKey{}, resp.KeyID), nil
+}
+
+func (a *Authenticator) clock() time.Time {
+	if a.now != nil {
+		return a.now()
+	}
+	return time.Now()
+}
+
+func (a *Authenticator) lockedOut(host string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	f, ok := a.failures[host]
+	return ok && a.clock().Before(f.until)
+}
+
+func (a *Authenticator) fail(host string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now, lockout := a.clock(), cmp.Or(a.Lockout, DefaultLockout)
+	if a.failures == nil {
+		a.failures = make(map[string]*authFailures)
+	}
+	if now.Sub(a.pruned) >= lockout {
+		a.prune(now, lockout)
+	}
+	f, ok := a.failures[host]
+	if !ok || a.stale(f, now, lockout) {
+		f = new(authFailures)
+		a.failures[host] = f
+	}
+	f.count++
+	f.last = now
+	if f.count >= cmp.Or(a.MaxFailures, DefaultMaxAuthFailures) {
+		f.count = 0
+		f.until = now.Add(lockout)
+	}
+}
+
+// prune forgets the hosts that are neither locked out nor failed recently,
+// so that hosts failing now and then do not pile up. a.mu must be held.
+func (a *Authenticator) prune(now time.Time, lockout time.Duration) {
+	for host, f := range a.failures {
+		if a.stale(f, now, lockout) {
+			delete(a.failures, host)
+		}
+	}
+	a.pruned = now
+}
+
+// stale reports whether f no longer counts: its lockout is over and its last
+// failure is older than lockout.
+func (a *Authenticator) stale(f *authFailures, now time.Time, lockout time.Duration) bool {
+	return !now.Before(f.until) && now.Sub(f.last) >= lockout
+}
+
+func (a *Authenticator) succeed(host string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.failures, host)
+}
+
+func hostOf(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// readAuth reads the next payload, which must be an Auth payload of one of
+// steps.
+func readAuth(c *FramedConn, steps ...uint8) (*Auth, error) {
+	p, err := c.ReadPayload()
+	if err != nil {
+		return nil, err
+	}
+	a, ok := p.(*Auth)
+	if !ok || !slices.Contains(steps, a.Step) {
+		return nil, fmt.Errorf("unexpected %v during authentication", p)
+	}
+	return a, nil
+}
@@ -0,0 +1,143 @@
+package tlv
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func testKeys(keyID string) ([]byte, bool) {
+	if keyID != "prober" {
+		return nil, false
+	}
+	return []byte("s3cr3t"), true
+}
+
+// handshake runs Authenticate against a.Accept over a fresh connection.
+func handshake(t *testing.T, a *Authenticator, keyID string, key []byte) (context.Context, error, error) {
+	t.Helper()
+	c1, c2 := tcpPair(t)
+	client, server := NewFramedConn(c1, Config{}), NewFramedConn(c2, Config{})
+	clientErr := make(chan error, 1)
+	go func() { clientErr <- Authenticate(context.Background(), client, keyID, key) }()
+	ctx, err := a.Accept(context.Background(), server)
+	return ctx, err, <-clientErr
+}
+
+func TestAuthenticate(t *testing.T) {
+	a := &Authenticator{Keys: testKeys}
+	ctx, err, clientErr := handshake(t, a, "prober", []byte("s3cr3t"))
+	if err != nil || clientErr != nil {
+		t.Fatalf("expected the handshake to succeed; actual %v, %v", err, clientErr)
+	}
+	if id, ok := Identity(ctx); !ok || id != "prober" {
+		t.Errorf("expected the identity %q; actual %q, %t", "prober", id, ok)
+	}
+
+	for _, c := range []struct{ keyID, key string }{{"prober", "guess"}, {"nobody", "s3cr3t"}} {
+		ctx, err, clientErr := handshake(t, a, c.keyID, []byte(c.key))
+		if !errors.Is(err, ErrAuthFailed) || !errors.Is(clientErr, ErrAuthFailed) {
+			t.Errorf("%s/%s: expected ErrAuthFailed on both ends; actual %v, %v", c.keyID, c.key, err, clientErr)
+		}
+		if _, ok := Identity(ctx); ok {
+			t.Errorf("%s/%s: expected no identity", c.keyID, c.key)
+		}
+	}
+}
+
+func TestAuthenticateReplay(t *testing.T) {
+	a := &Authenticator{Keys: testKeys}
+
+	// Record a genuine answer to a challenge of a.
+	c1, c2 := tcpPair(t)
+	client, server := NewFramedConn(c1, Config{}), NewFramedConn(c2, Config{})
+	accepted := make(chan error, 1)
+	go func() {
+		_, err := a.Accept(context.Background(), server)
+		accepted <- err
+	}()
+	challenge, err := readAuth(client, AuthChallenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorded := &Auth{Step: AuthResponse, KeyID: "prober", MAC: authMAC([]byte("s3cr3t"), "prober", challenge.Nonce)}
+	if err := client.WritePayload(recorded); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-accepted; err != nil {
+		t.Fatalf("expected the genuine answer to be accepted; actual %v", err)
+	}
+
+	// Replaying it to a real challenge fails.
+	c3, c4 := tcpPair(t)
+	attacker, victim := NewFramedConn(c3, Config{}), NewFramedConn(c4, Config{})
+	go func() {
+		if _, err := readAuth(attacker, AuthChallenge); err == nil {
+			_ = attacker.WritePayload(recorded)
+		}
+	}()
+	if _, err := a.Accept(context.Background(), victim); !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("expected a replayed response to fail; actual %v", err)
+	}
+}
+
+func TestAuthenticateLockout(t *testing.T) {
+	now := time.Now()
+	a := &Authenticator{Keys: testKeys, MaxFailures: 2, Lockout: time.Minute}
+	a.now = func() time.Time { return now }
+
+	for range 2 {
+		if _, err, _ := handshake(t, a, "prober", []byte("guess")); !errors.Is(err, ErrAuthFailed) {
+			t.Fatalf("expected ErrAuthFailed; actual %v", err)
+		}
+	}
+	// Even the right key is refused while locked out.
+	if _, err, clientErr := handshake(t, a, "prober", []byte("s3cr3t")); !errors.Is(err, ErrLockedOut) || !errors.Is(clientErr, ErrLockedOut) {
+		t.Errorf("expected ErrLockedOut on both ends; actual %v, client %v", err, clientErr)
+	}
+
+	now = now.Add(time.Minute)
+	if _, err, _ := handshake(t, a, "prober", []byte("s3cr3t")); err != nil {
+		t.Errorf("expected the lockout to expire; actual %v", err)
+	}
+}
+
+func TestAuthenticateForget(t *testing.T) {
+	now := time.Now()
+	a := &Authenticator{Keys: testKeys, MaxFailures: 2, Lockout: time.Minute}
+	a.now = func() time.Time { return now }
+
+	// A failure older than Lockout no longer counts towards a lockout.
+	if _, err, _ := handshake(t, a, "prober", []byte("guess")); !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("expected ErrAuthFailed; actual %v", err)
+	}
+	now = now.Add(time.Minute)
+	if _, err, _ := handshake(t, a, "prober", []byte("guess")); !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("expected ErrAuthFailed; actual %v", err)
+	}
+	if _, err, _ := handshake(t, a, "prober", []byte("s3cr3t")); err != nil {
+		t.Errorf("expected no lockout; actual %v", err)
+	}
+
+	// Hosts that stopped failing are pruned.
+	a.failures["192.0.2.1"] = &authFailures{count: 1, last: now}
+	now = now.Add(time.Minute)
+	if _, err, _ := handshake(t, a, "prober", []byte("guess")); !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("expected ErrAuthFailed; actual %v", err)
+	}
+	if _, ok := a.failures["192.0.2.1"]; ok || len(a.failures) != 1 {
+		t.Errorf("expected only the last failure to be kept; actual %d hosts", len(a.failures))
+	}
+}
+
+func TestAuthenticateCanceled(t *testing.T) {
+	c1, _ := tcpPair(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	// The server never sends a challenge.
+	err := Authenticate(ctx, NewFramedConn(c1, Config{}), "prober", []byte("s3cr3t"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded; actual %v", err)
+	}
+}
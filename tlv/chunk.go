@@ -0,0 +1,187 @@
+package tlv
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ChunkType is the type of the frames a chunked message is split into.
+const ChunkType = ReservedType + 1
+
+const (
+	// DefaultChunkSize is the chunk size of a ChunkWriter when none is given.
+	DefaultChunkSize = 64 << 10
+	// DefaultMaxMessageSize bounds a chunked message when no limit is given.
+	DefaultMaxMessageSize int64 = 1 << 30
+)
+
+// ErrMaxMessageSize is returned by a ChunkReader once the message grows past
+// its limit.
+var ErrMaxMessageSize = errors.New("maximum message size exceeded")
+
+const chunkLast = 1 << 0
+
+// Chunk is a piece of a message too large for a single frame. Its body is a
+// flags byte followed by the data; the last chunk of a message has Last set.
+type Chunk struct {
+	Last bool
+	Data []byte
+}
+
+func (c *Chunk) String() string {
+	return fmt.Sprintf("chunk of %d bytes (last: %t)", len(c.Data), c.Last)
+}
+
+func (c *Chunk) Bytes() []byte {
+	var flags byte
+	if c.Last {
+		flags |= chunkLast
+	}
+	return append([]byte{flags}, c.Data...)
+}
+
+func (c *Chunk) WriteTo(w io.Writer) (int64, error) {
+	return WriteFrame(w, ChunkType, c.Bytes())
+}
+
+func (c *Chunk) ReadFrom(r io.Reader) (int64, error) {
+	body, n, err := ReadBody(r)
+	if err != nil {
+		return n, err
+	}
+	if len(body) == 0 {
+		return n, errors.New("chunk without flags")
+	}
+	c.Last = body[0]&chunkLast != 0
+	c.Data = body[1:]
+	return n, nil
+}
+
+// ChunkWriter splits the message written to it into chunks and hands them to
+// send, usually the WritePayload method of a FramedConn, which must not keep
+// the chunk once it returns. Close sends the last chunk and must be called to
+// end the message.
+type ChunkWriter struct {
+	send func(io.WriterTo) error
+	buf  []byte
+	err  error
+}
+
+// NewChunkWriter returns a ChunkWriter sending chunks of up to size bytes,
+// DefaultChunkSize if size is not positive. Chunks are cut to fit
+// MaxPayloadSize only; use FramedConn.ChunkWriter to fit the payload size a
+// connection was configured with.
+func NewChunkWriter(send func(io.WriterTo) error, size int) *ChunkWriter {
+	return newChunkWriter(send, size, MaxPayloadSize)
+}
+
+// ChunkWriter returns a ChunkWriter sending a message on c in chunks of up
+// to size bytes, DefaultChunkSize if size is not positive, cut to fit
+// Config.MaxPayloadSize.
+func (c *FramedConn) ChunkWriter(size int) *ChunkWriter {
+	return newChunkWriter(c.WritePayload, size, c.max)
+}
+
+func newChunkWriter(send func(io.WriterTo) error, size int, maxPayload uint32) *ChunkWriter {
+	if size <= 0 {
+		size = DefaultChunkSize
+	}
+	// A chunk's body is its flags byte and its data.
+	size = max(min(size, int(maxPayload)-1), 1)
+	return &ChunkWriter{send: send, buf: make([]byte, 0, size)}
+}
+
+func (w *ChunkWriter) Write(b []byte) (int, error) {
+	var n int
+	for len(b) > 0 {
+		if w.err != nil {
+			return n, w.err
+		}
+		m := min(len(b), cap(w.buf)-len(w.buf))
+		w.buf = append(w.buf, b[:m]...)
+		n += m
+		b = b[m:]
+		// Hold a full chunk back until more data shows up, so that Close
+		// can mark it as the last one.
+		if len(w.buf) == cap(w.buf) && len(b) > 0 {
+			w.flush(false)
+		}
+	}
+	return n, w.err
+}
+
+// Close sends whatever is buffered as the last chunk of the message.
+func (w *ChunkWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	w.flush(true)
+	if w.err == nil {
+		w.err = errors.New("chunk writer closed")
+		return nil
+	}
+	return w.err
+}
+
+func (w *ChunkWriter) flush(last bool) {
+	w.err = w.send(&Chunk{Last: last, Data: w.buf})
+	w.buf = w.buf[:0]
+}
+
+// ChunkReader reads a chunked message as a stream, pulling one chunk at a
+// time from next, usually the ReadPayload method of a FramedConn. Only the
+// current chunk is held in memory.
+type ChunkReader struct {
+	next  func() (Payload, error)
+	limit int64
+	read  int64
+	chunk *Chunk
+	err   error
+}
+
+// NewChunkReader returns a ChunkReader for a message of at most limit bytes,
+// DefaultMaxMessageSize if limit is not positive. The next payload read must
+// be the first chunk of the message.
+func NewChunkReader(next func() (Payload, error), limit int64) *ChunkReader {
+	if limit <= 0 {
+		limit = DefaultMaxMessageSize
+	}
+	return &ChunkReader{next: next, limit: limit}
+}
+
+func (r *ChunkReader) Read(b []byte) (int, error) {
+	for r.err == nil && (r.chunk == nil || len(r.chunk.Data) == 0) {
+		if r.chunk != nil && r.chunk.Last {
+			r.err = io.EOF
+			break
+		}
+		r.err = r.nextChunk()
+	}
+	if r.err != nil {
+		return 0, r.err
+	}
+	n := copy(b, r.chunk.Data)
+	r.chunk.Data = r.chunk.Data[n:]
+	return n, nil
+}
+
+func (r *ChunkReader) nextChunk() error {
+	p, err := r.next()
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return err
+	}
+	c, ok := p.(*Chunk)
+	if !ok {
+		return fmt.Errorf("unexpected %T payload in a chunked message", p)
+	}
+	r.read += int64(len(c.Data))
+	if r.read > r.limit {
+		return fmt.Errorf("%w: more than %d bytes", ErrMaxMessageSize, r.limit)
+	}
+	r.chunk = c
+	return nil
+}
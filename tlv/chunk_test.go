@@ -0,0 +1,106 @@
+package tlv
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestChunkedMessage(t *testing.T) {
+	c1, c2 := tcpPair(t)
+	w, r := NewFramedConn(c1, Config{}), NewFramedConn(c2, Config{MaxPayloadSize: 1 << 10})
+
+	// Far larger than what a single frame of the receiver may carry.
+	msg := make([]byte, 3<<20+17)
+	_, _ = rand.Read(msg)
+	go func() {
+		cw := NewChunkWriter(w.WritePayload, 1000)
+		if _, err := io.Copy(cw, bytes.NewReader(msg)); err != nil {
+			t.Error(err)
+		}
+		if err := cw.Close(); err != nil {
+			t.Error(err)
+		}
+		_ = w.WritePayload(String("after"))
+	}()
+
+	h := sha256.New()
+	n, err := io.Copy(h, NewChunkReader(r.ReadPayload, int64(len(msg))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := sha256.Sum256(msg); n != int64(len(msg)) || !bytes.Equal(h.Sum(nil), expected[:]) {
+		t.Fatalf("expected the %d bytes sent; got %d different ones", len(msg), n)
+	}
+	// The message ends with its last chunk; the stream goes on.
+	if p, err := r.ReadPayload(); err != nil || p.String() != "after" {
+		t.Errorf("expected %q; actual %v, %v", "after", p, err)
+	}
+}
+
+func TestChunkReaderErrors(t *testing.T) {
+	buf := new(bytes.Buffer)
+	cw := NewChunkWriter(func(p io.WriterTo) error {
+		_, err := p.WriteTo(buf)
+		return err
+	}, 4)
+	_, _ = cw.Write([]byte("0123456789"))
+	_ = cw.Close()
+	frames := buf.Bytes()
+
+	next := func() (Payload, error) { return Decode(buf) }
+	if _, err := io.ReadAll(NewChunkReader(next, 9)); !errors.Is(err, ErrMaxMessageSize) {
+		t.Errorf("expected ErrMaxMessageSize; actual %v", err)
+	}
+
+	buf = bytes.NewBuffer(frames[:len(frames)-7])
+	if _, err := io.ReadAll(NewChunkReader(next, 0)); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF for a truncated message; actual %v", err)
+	}
+
+	buf = new(bytes.Buffer)
+	_, _ = String("not a chunk").WriteTo(buf)
+	if _, err := io.ReadAll(NewChunkReader(next, 0)); err == nil {
+		t.Error("expected an error for a payload that is not a chunk")
+	}
+
+	buf = new(bytes.Buffer)
+	empty := NewChunkWriter(func(p io.WriterTo) error {
+		_, err := p.WriteTo(buf)
+		return err
+	}, 0)
+	_ = empty.Close()
+	if b, err := io.ReadAll(NewChunkReader(next, 0)); err != nil || len(b) != 0 {
+		t.Errorf("expected an empty message; actual %q, %v", b, err)
+	}
+}
+
+func TestChunkWriterFitsConn(t *testing.T) {
+	c1, c2 := tcpPair(t)
+	cfg := Config{MaxPayloadSize: 1 << 10}
+	w, r := NewFramedConn(c1, cfg), NewFramedConn(c2, cfg)
+
+	// The default chunk size would not fit a frame of either end.
+	msg := make([]byte, 3*DefaultChunkSize)
+	_, _ = rand.Read(msg)
+	go func() {
+		cw := w.ChunkWriter(0)
+		if _, err := cw.Write(msg); err != nil {
+			t.Error(err)
+		}
+		if err := cw.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	got, err := io.ReadAll(NewChunkReader(r.ReadPayload, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, msg) {
+		t.Errorf("expected the %d bytes sent; got %d different ones", len(msg), len(got))
+	}
+}
@@ -0,0 +1,151 @@
+package tlv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codec is a compression algorithm for frame bodies. It is stored in the
+// flags of a versioned frame, shifted by one bit.
+type Codec uint8
+
+const (
+	Gzip Codec = iota + 1
+	Zstd
+)
+
+const (
+	codecShift = 1
+	codecMask  = 0b11 << codecShift
+)
+
+// DefaultCompressThreshold is the body size below which frames are sent
+// uncompressed when Config.CompressThreshold is zero.
+const DefaultCompressThreshold = 1 << 10
+
+// HelloType is the type of the Hello payload.
+const HelloType = ReservedType + 2
+
+func (c Codec) String() string {
+	switch c {
+	case Gzip:
+		return "gzip"
+	case Zstd:
+		return "zstd"
+	default:
+		return fmt.Sprintf("codec(%d)", uint8(c))
+	}
+}
+
+// Hello announces the codecs a FramedConn can decompress. A FramedConn
+// configured with codecs sends it ahead of its first payload and compresses
+// its frames once it received the Hello of its peer, with the first codec of
+// its own list the peer supports. Hello never reaches the application.
+type Hello struct {
+	Codecs []Codec
+}
+
+func (h *Hello) String() string { return fmt.Sprintf("hello %v", h.Codecs) }
+
+func (h *Hello) Bytes() []byte {
+	b := make([]byte, len(h.Codecs))
+	for i, c := range h.Codecs {
+		b[i] = byte(c)
+	}
+	return b
+}
+
+func (h *Hello) WriteTo(w io.Writer) (int64, error) {
+	return WriteFrame(w, HelloType, h.Bytes())
+}
+
+func (h *Hello) ReadFrom(r io.Reader) (int64, error) {
+	body, n, err := ReadBody(r)
+	if err != nil {
+		return n, err
+	}
+	h.Codecs = make([]Codec, len(body))
+	for i, c := range body {
+		h.Codecs[i] = Codec(c)
+	}
+	return n, nil
+}
+
+var (
+	gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+
+	zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	// Decoders stream, so that decompress stops at the limit of the
+	// connection, and refuse windows larger than a frame may carry.
+	zstdDecoders = sync.Pool{New: func() any {
+		d, _ := zstd.NewReader(nil,
+			zstd.WithDecoderConcurrency(1),
+			zstd.WithDecoderMaxWindow(uint64(MaxPayloadSize)),
+			zstd.WithDecoderMaxMemory(uint64(MaxPayloadSize)))
+		return d
+	}}
+)
+
+// compress appends body compressed with codec to dst.
+func compress(dst []byte, codec Codec, body []byte) ([]byte, error) {
+	switch codec {
+	case Gzip:
+		buf := bytes.NewBuffer(dst)
+		zw := gzipWriters.Get().(*gzip.Writer)
+		defer gzipWriters.Put(zw)
+		zw.Reset(buf)
+		if _, err := zw.Write(body); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case Zstd:
+		return zstdEncoder.EncodeAll(body, dst), nil
+	default:
+		return nil, fmt.Errorf("unsupported codec %v", codec)
+	}
+}
+
+// decompress returns body decompressed with codec, failing with
+// ErrMaxPayLoadSize as soon as it grows past limit bytes.
+func decompress(codec Codec, body []byte, limit uint32) ([]byte, error) {
+	var zr io.Reader
+	switch codec {
+	case Gzip:
+		r, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		zr = r
+	case Zstd:
+		d := zstdDecoders.Get().(*zstd.Decoder)
+		defer zstdDecoders.Put(d)
+		if err := d.Reset(bytes.NewReader(body)); err != nil {
+			return nil, err
+		}
+		// Let go of body before the decoder goes back to the pool.
+		defer d.Reset(nil)
+		zr = d
+	default:
+		return nil, fmt.Errorf("unsupported codec %v", codec)
+	}
+	out, err := io.ReadAll(io.LimitReader(zr, int64(limit)+1))
+	if errors.Is(err, zstd.ErrDecoderSizeExceeded) || errors.Is(err, zstd.ErrWindowSizeExceeded) {
+		return nil, fmt.Errorf("%w: decompressed %v body", ErrMaxPayLoadSize, codec)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > int(limit) {
+		return nil, fmt.Errorf("%w: decompressed %v body", ErrMaxPayLoadSize, codec)
+	}
+	return out, nil
+}
@@ -0,0 +1,146 @@
+package tlv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// tap records what is written to a conn.
+type tap struct {
+	net.Conn
+	mu  sync.Mutex
+	out bytes.Buffer
+}
+
+func (t *tap) Write(b []byte) (int, error) {
+	t.mu.Lock()
+	t.out.Write(b)
+	t.mu.Unlock()
+	return t.Conn.Write(b)
+}
+
+// flags returns the flags of every versioned frame recorded, skipping hellos.
+func (t *tap) flags() []uint8 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var flags []uint8
+	b := t.out.Bytes()
+	for len(b) >= headerSize+5 {
+		n := headerSize + 5 + int(binary.BigEndian.Uint32(b[headerSize+1:]))
+		if b[2]&FlagChecksum != 0 {
+			n += trailerSize
+		}
+		if b[headerSize] != HelloType {
+			flags = append(flags, b[2])
+		}
+		b = b[n:]
+	}
+	return flags
+}
+
+func TestFramedConnCompression(t *testing.T) {
+	c1, c2 := tcpPair(t)
+	client := &tap{Conn: c1}
+	server := &tap{Conn: c2}
+	cc := NewFramedConn(client, Config{Compression: []Codec{Zstd, Gzip}, Checksum: true})
+	sc := NewFramedConn(server, Config{Compression: []Codec{Gzip}})
+
+	large := String(strings.Repeat("compress me ", 1000))
+	exchange := func(from, to *FramedConn, p String) {
+		t.Helper()
+		if err := from.WritePayload(p); err != nil {
+			t.Fatal(err)
+		}
+		actual, err := to.ReadPayload()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual.String() != p.String() {
+			t.Fatalf("expected %d bytes; actual %d", len(p), len(actual.String()))
+		}
+	}
+	exchange(cc, sc, large) // before the server said hello
+	exchange(sc, cc, large)
+	exchange(sc, cc, "small")
+	exchange(cc, sc, large)
+
+	gzip := uint8(Gzip) << codecShift
+	if actual := client.flags(); !bytes.Equal(actual, []uint8{FlagChecksum, FlagChecksum | gzip}) {
+		t.Errorf("client: expected a raw frame, then a gzip one; actual flags %v", actual)
+	}
+	if actual := server.flags(); !bytes.Equal(actual, []uint8{gzip, 0}) {
+		t.Errorf("server: expected a gzip frame, then a raw small one; actual flags %v", actual)
+	}
+}
+
+func TestFramedConnDecompressionBomb(t *testing.T) {
+	for _, codec := range []Codec{Gzip, Zstd} {
+		c1, c2 := tcpPair(t)
+		w := NewFramedConn(c1, Config{Compression: []Codec{codec}})
+		w.codec.Store(uint32(codec))
+		r := NewFramedConn(c2, Config{Compression: []Codec{codec}, MaxPayloadSize: 1 << 10})
+
+		// Compresses to a fraction of the reader's limit.
+		if err := w.WritePayload(Binary(make([]byte, 1<<20))); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.ReadPayload(); !errors.Is(err, ErrMaxPayLoadSize) {
+			t.Errorf("%v: expected ErrMaxPayLoadSize; actual %v", codec, err)
+		}
+	}
+}
+
+func TestFramedConnUnsupportedCodec(t *testing.T) {
+	c1, c2 := tcpPair(t)
+	w := NewFramedConn(c1, Config{Compression: []Codec{Zstd}})
+	w.codec.Store(uint32(Zstd))
+	r := NewFramedConn(c2, Config{})
+
+	if err := w.WritePayload(String(strings.Repeat("z", 4096))); err != nil {
+		t.Fatal(err)
+	}
+	var hdrErr *HeaderError
+	if _, err := r.ReadPayload(); !errors.As(err, &hdrErr) {
+		t.Errorf("expected a HeaderError; actual %v", err)
+	}
+}
+
+func TestFramedConnHelloAfterFailedWrite(t *testing.T) {
+	c1, c2 := tcpPair(t)
+	w := NewFramedConn(c1, Config{Compression: []Codec{Gzip}, MaxPayloadSize: 1 << 10})
+	r := NewFramedConn(c2, Config{Compression: []Codec{Gzip}})
+
+	if err := w.WritePayload(Binary(make([]byte, 2<<10))); !errors.Is(err, ErrMaxPayLoadSize) {
+		t.Fatalf("expected ErrMaxPayLoadSize; actual %v", err)
+	}
+	if err := w.WritePayload(String("small")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ReadPayload(); err != nil {
+		t.Fatal(err)
+	}
+	if codec := Codec(r.codec.Load()); codec != Gzip {
+		t.Errorf("expected the peer to learn our codecs; actual codec %v", codec)
+	}
+}
+
+func TestDecompressLimit(t *testing.T) {
+	body := bytes.Repeat([]byte("z"), 4<<10)
+	for _, codec := range []Codec{Gzip, Zstd} {
+		compressed, err := compress(nil, codec, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out, err := decompress(codec, compressed, uint32(len(body))); err != nil || !bytes.Equal(out, body) {
+			t.Errorf("%v: expected the body back at its own size; actual %d bytes, %v", codec, len(out), err)
+		}
+		if _, err := decompress(codec, compressed, uint32(len(body))-1); !errors.Is(err, ErrMaxPayLoadSize) {
+			t.Errorf("%v: expected ErrMaxPayLoadSize one byte short; actual %v", codec, err)
+		}
+	}
+}
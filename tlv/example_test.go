@@ -0,0 +1,59 @@
+package tlv_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/vfor4/gonet/housework"
+	"github.com/vfor4/gonet/tlv"
+	"google.golang.org/protobuf/proto"
+)
+
+const choreType uint8 = 64
+
+// Chore carries a housework.Chore protobuf message.
+type Chore struct {
+	*housework.Chore
+}
+
+func (c *Chore) Bytes() []byte {
+	b, _ := proto.Marshal(c.Chore)
+	return b
+}
+
+func (c *Chore) WriteTo(w io.Writer) (int64, error) {
+	b, err := proto.Marshal(c.Chore)
+	if err != nil {
+		return 0, err
+	}
+	return tlv.WriteFrame(w, choreType, b)
+}
+
+func (c *Chore) ReadFrom(r io.Reader) (int64, error) {
+	body, n, err := tlv.ReadBody(r)
+	if err != nil {
+		return n, err
+	}
+	c.Chore = new(housework.Chore)
+	return n, proto.Unmarshal(body, c.Chore)
+}
+
+func ExampleRegister() {
+	if err := tlv.Register(choreType, func() tlv.Payload { return new(Chore) }); err != nil {
+		log.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	chore := &Chore{&housework.Chore{Description: "dishes"}}
+	if _, err := chore.WriteTo(buf); err != nil {
+		log.Fatal(err)
+	}
+	p, err := tlv.Decode(buf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(p.(*Chore).GetDescription())
+	// Output: dishes
+}
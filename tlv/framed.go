@@ -0,0 +1,374 @@
+package tlv
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Config configures a FramedConn.
+type Config struct {
+	// MaxPayloadSize bounds the body of the frames read and written on the
+	// connection. MaxPayloadSize if zero; it cannot be raised above it.
+	MaxPayloadSize uint32
+	// Registry decodes inbound frames. DefaultRegistry if nil.
+	Registry *Registry
+	// Checksum adds a CRC-32C trailer to the frames written. Frames read
+	// are checked whenever they carry one.
+	Checksum bool
+	// Legacy speaks the unversioned frames of peers that predate the frame
+	// header: frames are written without a header and read with or
+	// without one.
+	Legacy bool
+	// Compression lists the codecs the connection decompresses, in the
+	// order it prefers to compress with, see Hello. Ignored with Legacy.
+	Compression []Codec
+	// CompressThreshold is the body size below which frames are sent
+	// uncompressed. DefaultCompressThreshold if zero.
+	CompressThreshold int
+	// TLS lets the peer upgrade the connection with StartTLS; this end
+	// acts as the TLS server. ServerTLSConfig returns the usual settings.
+	TLS *tls.Config
+	// RequireTLS refuses application payloads until the connection was
+	// upgraded.
+	RequireTLS bool
+}
+
+// FramedConn reads and writes payloads on a net.Conn. Frames are written
+// whole under a mutex, so any number of goroutines may call WritePayload
+// concurrently; ReadPayload calls are serialized as well.
+//
+// A frame with an unknown type is skipped and reported as an
+// *UnknownTypeError, leaving the connection usable. Any other read error,
+// an oversized frame or a bad header included, leaves the stream misaligned
+// and is returned by every later ReadPayload. A *ChecksumError also closes
+// the connection. Likewise, a failed write may leave part of a frame on the
+// wire, so its error is returned by every later WritePayload.
+type FramedConn struct {
+	// raw is the connection given to NewFramedConn; conn is the one frames
+	// travel on, which StartTLS replaces while holding rmu, wmu and cmu.
+	raw  net.Conn
+	cmu  sync.Mutex
+	conn net.Conn
+	tls  *tls.Conn
+	cfg  Config
+	reg  *Registry
+	max  uint32
+
+	rmu  sync.Mutex
+	r    *bufio.Reader
+	rerr error
+
+	wmu       sync.Mutex
+	werr      error
+	buf       bytes.Buffer
+	helloSent bool
+	// codec compresses the frames written, once the peer said hello.
+	codec atomic.Uint32
+
+	closed    atomic.Bool
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewFramedConn wraps conn. The caller must not read from or write to conn
+// directly afterwards.
+func NewFramedConn(conn net.Conn, cfg Config) *FramedConn {
+	if cfg.MaxPayloadSize == 0 || cfg.MaxPayloadSize > MaxPayloadSize {
+		cfg.MaxPayloadSize = MaxPayloadSize
+	}
+	if cfg.Registry == nil {
+		cfg.Registry = DefaultRegistry
+	}
+	if cfg.CompressThreshold == 0 {
+		cfg.CompressThreshold = DefaultCompressThreshold
+	}
+	return &FramedConn{
+		raw:  conn,
+		conn: conn,
+		cfg:  cfg,
+		reg:  cfg.Registry,
+		max:  cfg.MaxPayloadSize,
+		r:    bufio.NewReader(conn),
+	}
+}
+
+// ReadPayload reads the next payload from the connection.
+func (c *FramedConn) ReadPayload() (Payload, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	if c.closed.Load() {
+		return nil, net.ErrClosed
+	}
+	if c.rerr != nil {
+		return nil, c.rerr
+	}
+	for {
+		p, err := c.readPayload()
+		switch p := p.(type) {
+		case *Hello:
+			c.hello(p)
+			continue
+		case *StartTLS:
+			if err := c.serveStartTLS(p); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err == nil && c.cfg.RequireTLS && c.tls == nil {
+			return nil, ErrTLSRequired
+		}
+		return p, err
+	}
+}
+
+// hello picks the codec to compress with from the ones the peer supports.
+func (c *FramedConn) hello(h *Hello) {
+	var codec Codec
+	for _, want := range c.cfg.Compression {
+		if slices.Contains(h.Codecs, want) {
+			codec = want
+			break
+		}
+	}
+	c.codec.Store(uint32(codec))
+}
+
+func (c *FramedConn) readPayload() (Payload, error) {
+	// Nothing is consumed until the whole header is there, so an error
+	// here, such as a read deadline, leaves the stream aligned.
+	first, err := c.r.Peek(1)
+	if err != nil {
+		return nil, c.readErr(err, false)
+	}
+	if first[0] != Magic {
+		if !c.cfg.Legacy {
+			return nil, c.readErr(&HeaderError{Header: first, Reason: "unversioned frame"}, true)
+		}
+		return c.readLegacy()
+	}
+
+	hdr, err := c.r.Peek(headerSize + 5)
+	if err != nil {
+		return nil, c.readErr(err, false)
+	}
+	switch {
+	case hdr[1] != Version:
+		return nil, c.readErr(&HeaderError{Header: hdr[:headerSize], Reason: "unsupported version"}, true)
+	case hdr[2]&^(FlagChecksum|codecMask) != 0:
+		return nil, c.readErr(&HeaderError{Header: hdr[:headerSize], Reason: "unsupported flags"}, true)
+	}
+	codec := Codec((hdr[2] & codecMask) >> codecShift)
+	if codec != 0 && (c.cfg.Legacy || !slices.Contains(c.cfg.Compression, codec)) {
+		return nil, c.readErr(&HeaderError{Header: hdr[:headerSize], Reason: "unsupported codec " + codec.String()}, true)
+	}
+	size := binary.BigEndian.Uint32(hdr[headerSize+1:])
+	if size > c.max {
+		return nil, c.readErr(fmt.Errorf("%w: %d bytes", ErrMaxPayLoadSize, size), true)
+	}
+	n := headerSize + 5 + int(size)
+	if hdr[2]&FlagChecksum != 0 {
+		n += trailerSize
+	}
+	frame := make([]byte, n)
+	if _, err := io.ReadFull(c.r, frame); err != nil {
+		return nil, c.readErr(err, true)
+	}
+	if frame[2]&FlagChecksum != 0 {
+		want := binary.BigEndian.Uint32(frame[n-trailerSize:])
+		if got := crc32.Checksum(frame[:n-trailerSize], castagnoli); got != want {
+			err := c.readErr(&ChecksumError{Want: want, Got: got}, true)
+			_ = c.raw.Close()
+			return nil, err
+		}
+	}
+	body := frame[headerSize : headerSize+5+int(size)]
+	if codec != 0 {
+		data, err := decompress(codec, body[5:], c.max)
+		if err != nil {
+			return nil, c.readErr(err, true)
+		}
+		body = binary.BigEndian.AppendUint32(body[:1:1], uint32(len(data)))
+		body = append(body, data...)
+	}
+	// The frame was read whole, so an unknown type leaves nothing to skip.
+	p, err := c.reg.Decode(bytes.NewReader(body))
+	var unknown *UnknownTypeError
+	if err != nil && !errors.As(err, &unknown) {
+		return nil, c.readErr(err, true)
+	}
+	return p, err
+}
+
+// readLegacy reads an unversioned frame.
+func (c *FramedConn) readLegacy() (Payload, error) {
+	hdr, err := c.r.Peek(5)
+	if err != nil {
+		return nil, c.readErr(err, false)
+	}
+	size := binary.BigEndian.Uint32(hdr[1:])
+	if size > c.max {
+		return nil, c.readErr(fmt.Errorf("%w: %d bytes", ErrMaxPayLoadSize, size), true)
+	}
+	p, err := c.reg.Decode(c.r)
+	var unknown *UnknownTypeError
+	switch {
+	case errors.As(err, &unknown):
+		// Decode consumed the type byte only; skip the length and body.
+		if _, derr := c.r.Discard(4 + int(size)); derr != nil {
+			return nil, c.readErr(derr, true)
+		}
+		return nil, err
+	case err != nil:
+		return nil, c.readErr(err, true)
+	}
+	return p, nil
+}
+
+// readErr reports err as net.ErrClosed after Close, and remembers it when
+// the stream is no longer aligned on a frame.
+func (c *FramedConn) readErr(err error, misaligned bool) error {
+	if c.closed.Load() {
+		err = net.ErrClosed
+	}
+	if misaligned || c.closed.Load() {
+		c.rerr = err
+	}
+	return err
+}
+
+// WritePayload writes p as a single frame. p is a Payload, or any value
+// whose WriteTo writes exactly one frame, such as a String.
+func (c *FramedConn) WritePayload(p io.WriterTo) error {
+	if c.closed.Load() {
+		return net.ErrClosed
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.werr != nil {
+		return c.werr
+	}
+	c.buf.Reset()
+	hello := !c.cfg.Legacy && len(c.cfg.Compression) > 0 && !c.helloSent
+	if hello {
+		if err := c.appendFrame(&Hello{Codecs: c.cfg.Compression}, 0); err != nil {
+			return err
+		}
+	}
+	if err := c.appendFrame(p, Codec(c.codec.Load())); err != nil {
+		return err
+	}
+	if _, err := c.conn.Write(c.buf.Bytes()); err != nil {
+		return c.writeErr(err)
+	}
+	// The Hello goes out with the payload, or is retried with the next one.
+	if hello {
+		c.helloSent = true
+	}
+	return nil
+}
+
+// writeErr reports err as net.ErrClosed after Close, and remembers it since
+// the frame may have been written in part. c.wmu must be held.
+func (c *FramedConn) writeErr(err error) error {
+	if c.closed.Load() {
+		err = net.ErrClosed
+	}
+	c.werr = err
+	return err
+}
+
+// appendFrame appends p to c.buf as a frame whose body is compressed with
+// codec, if it is worth it.
+func (c *FramedConn) appendFrame(p io.WriterTo, codec Codec) error {
+	frame := c.buf.Len()
+	var flags uint8
+	if !c.cfg.Legacy {
+		if c.cfg.Checksum {
+			flags |= FlagChecksum
+		}
+		c.buf.Write([]byte{Magic, Version, flags})
+	}
+	start := c.buf.Len()
+	if _, err := p.WriteTo(&c.buf); err != nil {
+		return err
+	}
+	size := c.buf.Len() - start - 5
+	if size > int(c.max) {
+		return fmt.Errorf("%w: %d bytes", ErrMaxPayLoadSize, size)
+	}
+	if codec != 0 && !c.cfg.Legacy && size >= c.cfg.CompressThreshold {
+		b := c.buf.Bytes()
+		compressed, err := compress(nil, codec, b[start+5:])
+		if err != nil {
+			return err
+		}
+		if len(compressed) < size {
+			b[frame+2] |= uint8(codec) << codecShift
+			binary.BigEndian.PutUint32(b[start+1:], uint32(len(compressed)))
+			c.buf.Truncate(start + 5)
+			c.buf.Write(compressed)
+		}
+	}
+	if flags&FlagChecksum != 0 {
+		sum := crc32.Checksum(c.buf.Bytes()[frame:], castagnoli)
+		c.buf.Write(binary.BigEndian.AppendUint32(nil, sum))
+	}
+	return nil
+}
+
+// Close closes the connection, unblocking pending reads and writes. Later
+// calls to ReadPayload and WritePayload return net.ErrClosed. Close is safe to
+// call more than once.
+func (c *FramedConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.closed.Store(true)
+		c.closeErr = c.raw.Close()
+	})
+	return c.closeErr
+}
+
+// Err returns the error a failed read or write left the connection with, or
+// nil while the stream is aligned on a frame both ways. A read that timed
+// out before a frame started, for instance, leaves Err nil and the
+// connection usable.
+func (c *FramedConn) Err() error {
+	if c.closed.Load() {
+		return net.ErrClosed
+	}
+	c.rmu.Lock()
+	err := c.rerr
+	c.rmu.Unlock()
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return cmp.Or(err, c.werr)
+}
+
+// NetConn returns the connection frames travel on, a *tls.Conn once it was
+// upgraded with StartTLS.
+func (c *FramedConn) NetConn() net.Conn {
+	c.cmu.Lock()
+	defer c.cmu.Unlock()
+	return c.conn
+}
+
+func (c *FramedConn) LocalAddr() net.Addr { return c.raw.LocalAddr() }
+
+func (c *FramedConn) RemoteAddr() net.Addr { return c.raw.RemoteAddr() }
+
+func (c *FramedConn) SetDeadline(t time.Time) error { return c.raw.SetDeadline(t) }
+
+func (c *FramedConn) SetReadDeadline(t time.Time) error { return c.raw.SetReadDeadline(t) }
+
+func (c *FramedConn) SetWriteDeadline(t time.Time) error { return c.raw.SetWriteDeadline(t) }
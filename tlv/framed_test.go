@@ -0,0 +1,170 @@
+package tlv
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// tcpPair returns both ends of a loopback TCP connection.
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := l.Accept()
+		accepted <- conn
+	}()
+	c1, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2 := <-accepted
+	if c2 == nil {
+		t.Fatal("accept failed")
+	}
+	t.Cleanup(func() {
+		_ = c1.Close()
+		_ = c2.Close()
+	})
+	return c1, c2
+}
+
+func TestFramedConnConcurrentWrites(t *testing.T) {
+	c1, c2 := tcpPair(t)
+	w, r := NewFramedConn(c1, Config{}), NewFramedConn(c2, Config{})
+
+	const writers, frames = 10, 20
+	var wg sync.WaitGroup
+	for i := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range frames {
+				// Large enough to need several writes on the socket.
+				msg := fmt.Sprintf("%02d-%02d-%020000d", i, j, 0)
+				if err := w.WritePayload(String(msg)); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+
+	next := make(map[int]int)
+	for range writers * frames {
+		p, err := r.ReadPayload()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var i, j int
+		if _, err := fmt.Sscanf(p.String()[:5], "%02d-%02d", &i, &j); err != nil || len(p.Bytes()) != 20006 {
+			t.Fatalf("corrupted frame %.20q of %d bytes", p, len(p.Bytes()))
+		}
+		if next[i] != j {
+			t.Fatalf("writer %d: expected frame %d; actual %d", i, next[i], j)
+		}
+		next[i]++
+	}
+	wg.Wait()
+}
+
+func TestFramedConnLimits(t *testing.T) {
+	c1, c2 := tcpPair(t)
+	w := NewFramedConn(c1, Config{})
+	r := NewFramedConn(c2, Config{MaxPayloadSize: 8})
+
+	if err := r.WritePayload(String("too large")); !errors.Is(err, ErrMaxPayLoadSize) {
+		t.Errorf("expected ErrMaxPayLoadSize on write; actual %v", err)
+	}
+
+	// Unknown types are skipped, the next frame is still readable.
+	_ = w.WritePayload(Uint64(1))
+	_ = w.WritePayload(String("ok"))
+	var unknown *UnknownTypeError
+	if _, err := r.ReadPayload(); !errors.As(err, &unknown) {
+		t.Errorf("expected an UnknownTypeError; actual %v", err)
+	}
+	if p, err := r.ReadPayload(); err != nil || p.String() != "ok" {
+		t.Errorf("expected %q; actual %v, %v", "ok", p, err)
+	}
+
+	// An oversized frame breaks the stream for good.
+	_ = w.WritePayload(String("too large"))
+	_ = w.WritePayload(String("ok"))
+	for range 2 {
+		if _, err := r.ReadPayload(); !errors.Is(err, ErrMaxPayLoadSize) {
+			t.Errorf("expected ErrMaxPayLoadSize; actual %v", err)
+		}
+	}
+}
+
+func TestFramedConnTimeout(t *testing.T) {
+	c1, c2 := tcpPair(t)
+	w, r := NewFramedConn(c1, Config{}), NewFramedConn(c2, Config{})
+
+	_ = r.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	var netErr net.Error
+	if _, err := r.ReadPayload(); !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("expected a timeout; actual %v", err)
+	}
+	// A timeout before a frame started does not break the stream.
+	if err := r.Err(); err != nil {
+		t.Errorf("expected the stream to be aligned; actual %v", err)
+	}
+	_ = r.SetReadDeadline(time.Time{})
+	_ = w.WritePayload(String("late"))
+	if p, err := r.ReadPayload(); err != nil || p.String() != "late" {
+		t.Errorf("expected %q; actual %v, %v", "late", p, err)
+	}
+}
+
+func TestFramedConnWriteTimeout(t *testing.T) {
+	c1, _ := tcpPair(t)
+	w := NewFramedConn(c1, Config{})
+
+	// The peer does not read, so the deadline hits in the middle of a frame.
+	_ = w.SetWriteDeadline(time.Now().Add(10 * time.Millisecond))
+	var netErr net.Error
+	if err := w.WritePayload(Binary(make([]byte, MaxPayloadSize))); !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("expected a timeout; actual %v", err)
+	}
+	_ = w.SetWriteDeadline(time.Time{})
+	if err := w.WritePayload(String("late")); !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("expected the timeout again rather than a misaligned frame; actual %v", err)
+	}
+	if err := w.Err(); !errors.As(err, &netErr) {
+		t.Errorf("expected Err to report the timeout; actual %v", err)
+	}
+}
+
+func TestFramedConnClose(t *testing.T) {
+	c1, _ := tcpPair(t)
+	c := NewFramedConn(c1, Config{})
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := c.ReadPayload()
+		errs <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("expected a second Close to succeed; actual %v", err)
+	}
+	if err := <-errs; !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expected the pending read to return net.ErrClosed; actual %v", err)
+	}
+	if err := c.WritePayload(String("x")); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expected net.ErrClosed; actual %v", err)
+	}
+}
@@ -0,0 +1,51 @@
+package tlv
+
+import (
+	"fmt"
+	"hash/crc32"
+)
+
+// A versioned frame starts with a three byte header, Magic, Version and
+// flags, in front of the type, length and body of the unversioned frame.
+// The length is the one of the body on the wire, compressed or not.
+// With FlagChecksum set, a big-endian CRC-32C of the header, type, length
+// and body follows the body.
+const (
+	// Magic opens every versioned frame. It is a reserved type ID, so a
+	// reader can tell a versioned frame from an unversioned one.
+	Magic uint8 = 0xFF
+	// Version is the version of the frame format written by FramedConn.
+	Version uint8 = 1
+
+	// FlagChecksum marks a frame followed by a CRC-32C trailer. The next
+	// two bits hold the Codec the body is compressed with, if any.
+	FlagChecksum uint8 = 1 << 0
+
+	headerSize  = 3
+	trailerSize = 4
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ChecksumError is returned for a frame whose CRC-32C trailer does not match
+// its content. The connection is closed, since a corrupted stream cannot be
+// trusted to be aligned on frames anymore.
+type ChecksumError struct {
+	Want, Got uint32
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("frame checksum mismatch: want %#08x, got %#08x", e.Want, e.Got)
+}
+
+// HeaderError is returned for a frame whose header is not one this package
+// reads: an unknown version or flag, or no header at all when unversioned
+// frames are not accepted.
+type HeaderError struct {
+	Header []byte
+	Reason string
+}
+
+func (e *HeaderError) Error() string {
+	return fmt.Sprintf("bad frame header % x: %s", e.Header, e.Reason)
+}
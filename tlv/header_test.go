@@ -0,0 +1,91 @@
+package tlv
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// rawFrame returns the bytes a FramedConn configured with cfg writes for p.
+func rawFrame(t *testing.T, cfg Config, p Payload) []byte {
+	t.Helper()
+	c1, c2 := tcpPair(t)
+	if err := NewFramedConn(c1, cfg).WritePayload(p); err != nil {
+		t.Fatal(err)
+	}
+	_ = c1.Close()
+	buf := new(bytes.Buffer)
+	_, _ = buf.ReadFrom(c2)
+	return buf.Bytes()
+}
+
+func TestFramedConnChecksum(t *testing.T) {
+	ping := String("ping")
+	frame := rawFrame(t, Config{Checksum: true}, &ping)
+	if expected := []byte{Magic, Version, FlagChecksum, StringType, 0, 0, 0, 4, 'p', 'i', 'n', 'g'}; !bytes.HasPrefix(frame, expected) || len(frame) != len(expected)+4 {
+		t.Fatalf("unexpected frame % x", frame)
+	}
+
+	c1, c2 := tcpPair(t)
+	r := NewFramedConn(c2, Config{})
+	_, _ = c1.Write(frame)
+	if p, err := r.ReadPayload(); err != nil || p.String() != "ping" {
+		t.Fatalf("expected ping; actual %v, %v", p, err)
+	}
+
+	frame[9] ^= 0x20 // "pIng"
+	_, _ = c1.Write(frame)
+	var sumErr *ChecksumError
+	if _, err := r.ReadPayload(); !errors.As(err, &sumErr) {
+		t.Fatalf("expected a ChecksumError; actual %v", err)
+	}
+	// The reader gives up on the connection.
+	if _, err := c1.Read(make([]byte, 1)); err == nil {
+		t.Error("expected the connection to be closed")
+	}
+}
+
+func TestFramedConnHeader(t *testing.T) {
+	ping := String("ping")
+	legacy := rawFrame(t, Config{Legacy: true}, &ping)
+	if !bytes.Equal(legacy, []byte{StringType, 0, 0, 0, 4, 'p', 'i', 'n', 'g'}) {
+		t.Fatalf("unexpected legacy frame % x", legacy)
+	}
+	versioned := rawFrame(t, Config{}, &ping)
+
+	tests := []struct {
+		name  string
+		cfg   Config
+		frame []byte
+		ok    bool
+	}{
+		{"versioned", Config{}, versioned, true},
+		{"legacy accepted", Config{Legacy: true}, legacy, true},
+		{"versioned in legacy mode", Config{Legacy: true}, versioned, true},
+		{"legacy rejected", Config{}, legacy, false},
+		{"unknown version", Config{}, append([]byte{Magic, Version + 1, 0}, legacy...), false},
+		{"unknown flags", Config{}, append([]byte{Magic, Version, 0x80}, legacy...), false},
+	}
+	for _, c := range tests {
+		c1, c2 := tcpPair(t)
+		r := NewFramedConn(c2, c.cfg)
+		_, _ = c1.Write(c.frame)
+		p, err := r.ReadPayload()
+		if c.ok {
+			if err != nil || p.String() != "ping" {
+				t.Errorf("%s: expected ping; actual %v, %v", c.name, p, err)
+			}
+			continue
+		}
+		var hdrErr *HeaderError
+		if !errors.As(err, &hdrErr) {
+			t.Errorf("%s: expected a HeaderError; actual %v", c.name, err)
+		}
+	}
+}
+
+func TestRegisterMagic(t *testing.T) {
+	if err := NewRegistry().Register(Magic, func() Payload { return new(Binary) }); err == nil {
+		t.Error("expected registering the magic type to fail")
+	}
+}
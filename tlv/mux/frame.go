@@ -0,0 +1,68 @@
+package mux
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/vfor4/gonet/tlv"
+)
+
+// FrameType is the TLV type of the frames that carry streams.
+const FrameType = tlv.ReservedType
+
+// Frame kinds. A frame body is the stream ID (4), the kind (1) and the
+// kind's data: an inner TLV frame for kindData, a uint32 credit for
+// kindWindow and an error message for kindReset.
+const (
+	kindData uint8 = iota + 1
+	kindClose
+	kindReset
+	kindWindow
+)
+
+// frame is the payload multiplexed streams travel in.
+type frame struct {
+	stream uint32
+	kind   uint8
+	data   []byte
+
+	// reg decodes the inner payload of inbound data frames into payload;
+	// err is set instead when that fails, so that only the stream fails
+	// rather than the connection.
+	reg     *tlv.Registry
+	payload tlv.Payload
+	err     error
+}
+
+func (f *frame) String() string {
+	return fmt.Sprintf("stream %d kind %d (%d bytes)", f.stream, f.kind, len(f.data))
+}
+
+func (f *frame) Bytes() []byte {
+	b := binary.BigEndian.AppendUint32(nil, f.stream)
+	b = append(b, f.kind)
+	return append(b, f.data...)
+}
+
+func (f *frame) WriteTo(w io.Writer) (int64, error) {
+	return tlv.WriteFrame(w, FrameType, f.Bytes())
+}
+
+func (f *frame) ReadFrom(r io.Reader) (int64, error) {
+	body, n, err := tlv.ReadBody(r)
+	if err != nil {
+		return n, err
+	}
+	if len(body) < 5 {
+		return n, fmt.Errorf("mux: frame of %d bytes is too short", len(body))
+	}
+	f.stream = binary.BigEndian.Uint32(body)
+	f.kind = body[4]
+	f.data = body[5:]
+	if f.kind == kindData {
+		f.payload, f.err = f.reg.Decode(bytes.NewReader(f.data))
+	}
+	return n, nil
+}
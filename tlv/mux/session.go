@@ -0,0 +1,591 @@
+// Package mux runs many concurrent streams of TLV payloads over a single
+// connection. Every stream is carried in frames of FrameType that tag the
+// inner payload with a stream ID, so the payload types themselves do not
+// change.
+//
+// Each direction of a stream has a window of bytes the receiver buffers.
+// A sender blocks once it used its window until the receiver reads and
+// grants more, so a slow stream cannot stall the others sharing the
+// connection. Every stream starts with MinWindow of credit, and receivers
+// grant the rest of their own window once the stream is open, so the peers
+// need not agree on the window size.
+package mux
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/vfor4/gonet/tlv"
+)
+
+const (
+	// MinWindow is the credit every stream starts with in each direction,
+	// and the smallest window a session uses.
+	MinWindow = 64 << 10
+	// DefaultWindow is used when Config.Window is not positive.
+	DefaultWindow = 256 << 10
+	// DefaultMaxStreams is used when Config.MaxStreams is not positive.
+	DefaultMaxStreams = 100
+)
+
+var (
+	// ErrSendClosed is returned by Send after CloseSend.
+	ErrSendClosed = errors.New("mux: send on closed stream")
+	// ErrFlowControl resets a stream whose peer sent beyond its window.
+	ErrFlowControl = errors.New("mux: flow control window exceeded")
+	// ErrProtocol resets a stream whose peer broke the framing rules, such
+	// as sending data after closing its side.
+	ErrProtocol = errors.New("mux: protocol violation")
+	// ErrTooManyStreams resets the streams opened beyond Config.MaxStreams.
+	ErrTooManyStreams = errors.New("mux: too many streams")
+)
+
+// ResetError is returned by the calls on a stream that the peer reset, with
+// the reason it gave.
+type ResetError struct {
+	Stream  uint32
+	Message string
+}
+
+func (e *ResetError) Error() string {
+	return fmt.Sprintf("mux: stream %d reset by peer: %s", e.Stream, e.Message)
+}
+
+// Config configures a Session.
+type Config struct {
+	// Conn configures the framing of the connection. Its Registry decodes
+	// the payloads carried by the streams, tlv.DefaultRegistry if nil.
+	Conn tlv.Config
+	// Window is how many bytes of frames a stream buffers before its peer
+	// must wait for it to read them; the last frame let in may overshoot
+	// it. DefaultWindow if not positive, and at least MinWindow.
+	Window int
+	// MaxStreams bounds how many streams the peer of a server session may
+	// have open at once. DefaultMaxStreams if not positive.
+	MaxStreams int
+}
+
+// Handler serves the streams opened by the peer of a server session.
+type Handler interface {
+	ServeStream(ctx context.Context, s *Stream)
+}
+
+// HandlerFunc serves request/response streams: it is called with the single
+// payload of the request, and its result is the response. An error resets
+// the stream and is reported to the caller as a *ResetError.
+type HandlerFunc func(ctx context.Context, req tlv.Payload) (tlv.Payload, error)
+
+func (f HandlerFunc) ServeStream(ctx context.Context, s *Stream) {
+	req, err := s.Recv(ctx)
+	if err != nil {
+		s.Reset(err)
+		return
+	}
+	resp, err := f(ctx, req)
+	if err == nil {
+		err = s.Send(ctx, resp)
+	}
+	if err != nil {
+		s.Reset(err)
+		return
+	}
+	_ = s.CloseSend()
+}
+
+// Session multiplexes streams over a connection. It is safe for concurrent
+// use.
+type Session struct {
+	conn       *tlv.FramedConn
+	window     int
+	maxStreams int
+	handler    Handler
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	streams map[uint32]*Stream
+	nextID  uint32
+	// lastPeer is the ID of the last stream the peer opened, peerStreams
+	// how many of the streams it opened are still open.
+	lastPeer    uint32
+	peerStreams int
+	err         error
+}
+
+// NewClient starts a session on conn that opens streams.
+func NewClient(conn net.Conn, cfg Config) *Session {
+	return newSession(conn, cfg, nil, 1)
+}
+
+// NewServer starts a session on conn that serves each stream opened by the
+// peer with h in a goroutine of its own.
+func NewServer(conn net.Conn, cfg Config, h Handler) *Session {
+	return newSession(conn, cfg, h, 2)
+}
+
+func newSession(conn net.Conn, cfg Config, h Handler, firstID uint32) *Session {
+	if cfg.Window <= 0 {
+		cfg.Window = DefaultWindow
+	}
+	cfg.Window = max(cfg.Window, MinWindow)
+	if cfg.MaxStreams <= 0 {
+		cfg.MaxStreams = DefaultMaxStreams
+	}
+	inner := cfg.Conn.Registry
+	if inner == nil {
+		inner = tlv.DefaultRegistry
+	}
+	// The connection decodes the frames, the frames decode what they carry
+	// with the caller's registry.
+	connCfg := cfg.Conn
+	connCfg.Registry = tlv.NewRegistry()
+	_ = connCfg.Registry.Register(FrameType, func() tlv.Payload { return &frame{reg: inner} })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Session{
+		conn:       tlv.NewFramedConn(conn, connCfg),
+		window:     cfg.Window,
+		maxStreams: cfg.MaxStreams,
+		handler:    h,
+		ctx:        ctx,
+		cancel:     cancel,
+		streams:    make(map[uint32]*Stream),
+		nextID:     firstID,
+	}
+	go s.readLoop()
+	return s
+}
+
+// Open starts a new stream.
+func (s *Session) Open() (*Stream, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	st := s.newStream(s.nextID)
+	s.nextID += 2
+	return st, nil
+}
+
+// Call sends req on a new stream and returns the single payload the peer
+// answers with. Canceling ctx resets the stream, which cancels the context
+// of the handler serving it.
+func (s *Session) Call(ctx context.Context, req io.WriterTo) (tlv.Payload, error) {
+	st, err := s.Open()
+	if err != nil {
+		return nil, err
+	}
+	if err := st.Send(ctx, req); err != nil {
+		st.Reset(err)
+		return nil, err
+	}
+	if err := st.CloseSend(); err != nil {
+		st.Reset(err)
+		return nil, err
+	}
+	resp, err := st.Recv(ctx)
+	if err != nil {
+		st.Reset(err)
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Close closes the connection. Every stream fails with net.ErrClosed.
+func (s *Session) Close() error {
+	s.fail(net.ErrClosed)
+	return s.conn.Close()
+}
+
+// Done is closed when the session ends; Err tells why.
+func (s *Session) Done() <-chan struct{} { return s.ctx.Done() }
+
+// Err returns the reason the session ended, or nil while it runs.
+func (s *Session) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// newStream registers a stream; s.mu must be held.
+func (s *Session) newStream(id uint32) *Stream {
+	ctx, cancel := context.WithCancel(s.ctx)
+	st := &Stream{
+		id:      id,
+		s:       s,
+		ctx:     ctx,
+		cancel:  cancel,
+		ready:   make(chan struct{}, 1),
+		credit:  MinWindow,
+		credits: make(chan struct{}, 1),
+	}
+	s.streams[id] = st
+	return st
+}
+
+func (s *Session) stream(id uint32) *Stream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.streams[id]
+}
+
+func (s *Session) remove(id uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.streams[id]; ok && id%2 != s.nextID%2 {
+		s.peerStreams--
+	}
+	delete(s.streams, id)
+}
+
+func (s *Session) fail(err error) {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return
+	}
+	s.err = err
+	streams := s.streams
+	s.streams = make(map[uint32]*Stream)
+	s.mu.Unlock()
+	for _, st := range streams {
+		st.fail(err)
+	}
+	s.cancel()
+	_ = s.conn.Close()
+}
+
+func (s *Session) write(id uint32, kind uint8, data []byte) error {
+	return s.conn.WritePayload(&frame{stream: id, kind: kind, data: data})
+}
+
+// accept returns the stream for a data frame with an ID we do not know: a
+// new stream if the peer may open it, nil otherwise. It returns
+// ErrTooManyStreams when the peer has MaxStreams open already.
+func (s *Session) accept(id uint32) (*Stream, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Peers open streams with the parity we do not use, in increasing order,
+	// so an ID at or below the last one is a stream that already ended.
+	if s.handler == nil || id%2 == s.nextID%2 || id <= s.lastPeer || s.err != nil {
+		return nil, nil
+	}
+	s.lastPeer = id
+	if s.peerStreams >= s.maxStreams {
+		return nil, ErrTooManyStreams
+	}
+	s.peerStreams++
+	st := s.newStream(id)
+	go func() {
+		s.handler.ServeStream(st.ctx, st)
+		// A handler that returns without closing its side is done with it.
+		_ = st.CloseSend()
+	}()
+	return st, nil
+}
+
+func (s *Session) readLoop() {
+	for {
+		p, err := s.conn.ReadPayload()
+		var unknown *tlv.UnknownTypeError
+		switch {
+		case errors.As(err, &unknown):
+			continue
+		case err != nil:
+			s.fail(err)
+			return
+		}
+		f, ok := p.(*frame)
+		if !ok {
+			s.fail(fmt.Errorf("mux: unexpected %T payload outside of a stream", p))
+			return
+		}
+		st := s.stream(f.stream)
+		if st == nil && f.kind == kindData {
+			st, err = s.accept(f.stream)
+			if err != nil {
+				_ = s.write(f.stream, kindReset, []byte(err.Error()))
+				continue
+			}
+			if st != nil {
+				st.advertise()
+			}
+		}
+		if st == nil {
+			// The stream ended on our side; frames in flight are dropped.
+			continue
+		}
+		switch f.kind {
+		case kindData:
+			if f.err != nil {
+				st.Reset(f.err)
+				continue
+			}
+			if err := st.push(f.payload, len(f.data)); err != nil {
+				st.Reset(err)
+			}
+		case kindClose:
+			st.closeRecv()
+		case kindReset:
+			st.fail(&ResetError{Stream: st.id, Message: string(f.data)})
+			s.remove(st.id)
+		case kindWindow:
+			if len(f.data) == 4 {
+				st.grant(int(binary.BigEndian.Uint32(f.data)))
+			}
+		}
+	}
+}
+
+// Stream is one bidirectional sequence of payloads of a Session. Send and
+// Recv may be called concurrently with each other.
+type Stream struct {
+	id     uint32
+	s      *Session
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu sync.Mutex
+	// queue holds the payloads received and not read yet, buffered the
+	// size of their frames. ready is signaled whenever either changes or
+	// the peer closes its side.
+	queue      []inbound
+	buffered   int
+	ready      chan struct{}
+	recvClosed bool
+	consumed   int
+	// credit is how many bytes we may still send; advertised is set once
+	// we granted the peer our window beyond MinWindow.
+	credit     int
+	credits    chan struct{}
+	advertised bool
+	sendClosed bool
+	err        error
+}
+
+type inbound struct {
+	p    tlv.Payload
+	size int
+}
+
+// ID returns the stream ID.
+func (st *Stream) ID() uint32 { return st.id }
+
+// Send sends p on the stream, waiting for the peer to grant credit if the
+// window is used up.
+func (st *Stream) Send(ctx context.Context, p io.WriterTo) error {
+	var buf bytes.Buffer
+	if _, err := p.WriteTo(&buf); err != nil {
+		return err
+	}
+	for {
+		st.mu.Lock()
+		switch {
+		case st.err != nil:
+			err := st.err
+			st.mu.Unlock()
+			return err
+		case st.sendClosed:
+			st.mu.Unlock()
+			return ErrSendClosed
+		case st.credit > 0:
+			st.credit -= buf.Len()
+			st.mu.Unlock()
+			if err := st.s.write(st.id, kindData, buf.Bytes()); err != nil {
+				return err
+			}
+			// The peer knows the stream now that it got data.
+			st.advertise()
+			return nil
+		}
+		st.mu.Unlock()
+		select {
+		case <-st.credits:
+		case <-st.ctx.Done():
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Recv returns the next payload of the stream, or io.EOF once the peer
+// closed its side and every payload was received.
+func (st *Stream) Recv(ctx context.Context) (tlv.Payload, error) {
+	for {
+		if p, ok, err := st.next(); ok {
+			return p, err
+		}
+		select {
+		case <-st.ready:
+		case <-st.ctx.Done():
+			// A stream ends failed or closed by the peer, so next has an
+			// answer; whatever is still queued after a clean close is read.
+			if p, ok, err := st.next(); ok {
+				return p, err
+			}
+			return nil, net.ErrClosed
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// next pops the next payload, and reports false if there is none yet.
+func (st *Stream) next() (tlv.Payload, bool, error) {
+	st.mu.Lock()
+	switch {
+	case st.err != nil:
+		err := st.err
+		st.mu.Unlock()
+		return nil, true, err
+	case len(st.queue) > 0:
+		in := st.queue[0]
+		st.queue[0] = inbound{}
+		st.queue = st.queue[1:]
+		st.buffered -= in.size
+		st.mu.Unlock()
+		st.consume(in.size)
+		return in.p, true, nil
+	case st.recvClosed:
+		st.mu.Unlock()
+		return nil, true, io.EOF
+	}
+	st.mu.Unlock()
+	return nil, false, nil
+}
+
+// push queues a payload received in a frame of size bytes.
+func (st *Stream) push(p tlv.Payload, size int) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	switch {
+	case st.err != nil:
+		return nil
+	case st.recvClosed:
+		return fmt.Errorf("%w: data after close", ErrProtocol)
+	case st.buffered >= st.s.window:
+		return ErrFlowControl
+	}
+	st.queue = append(st.queue, inbound{p: p, size: size})
+	st.buffered += size
+	st.signal()
+	return nil
+}
+
+// signal wakes up a pending Recv; st.mu must be held.
+func (st *Stream) signal() {
+	select {
+	case st.ready <- struct{}{}:
+	default:
+	}
+}
+
+// CloseSend tells the peer that no more payloads follow.
+func (st *Stream) CloseSend() error {
+	st.mu.Lock()
+	if st.err != nil || st.sendClosed {
+		st.mu.Unlock()
+		return nil
+	}
+	st.sendClosed = true
+	done := st.recvClosed
+	st.mu.Unlock()
+	err := st.s.write(st.id, kindClose, nil)
+	if done {
+		st.finish()
+	}
+	return err
+}
+
+// Reset abandons the stream in both directions and tells the peer why.
+func (st *Stream) Reset(reason error) {
+	if reason == nil {
+		reason = context.Canceled
+	}
+	if st.fail(reason) {
+		_ = st.s.write(st.id, kindReset, []byte(reason.Error()))
+	}
+	st.s.remove(st.id)
+}
+
+// fail ends the stream with err and reports whether it was still open.
+func (st *Stream) fail(err error) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.err != nil {
+		return false
+	}
+	st.err = err
+	st.cancel()
+	return true
+}
+
+// closeRecv handles the peer closing its side.
+func (st *Stream) closeRecv() {
+	st.mu.Lock()
+	if st.recvClosed {
+		st.mu.Unlock()
+		return
+	}
+	st.recvClosed = true
+	st.signal()
+	done := st.sendClosed
+	st.mu.Unlock()
+	if done {
+		st.finish()
+	}
+}
+
+// finish releases a stream closed in both directions.
+func (st *Stream) finish() {
+	st.s.remove(st.id)
+	st.cancel()
+}
+
+// advertise grants the peer our window beyond the MinWindow it started
+// with, once the peer knows the stream.
+func (st *Stream) advertise() {
+	st.mu.Lock()
+	if st.advertised || st.err != nil {
+		st.mu.Unlock()
+		return
+	}
+	st.advertised = true
+	st.mu.Unlock()
+	if n := st.s.window - MinWindow; n > 0 {
+		_ = st.s.write(st.id, kindWindow, binary.BigEndian.AppendUint32(nil, uint32(n)))
+	}
+}
+
+// consume grants the peer new credit once half the window was read.
+func (st *Stream) consume(size int) {
+	st.mu.Lock()
+	st.consumed += size
+	n := st.consumed
+	if n < max(st.s.window/2, 1) || st.err != nil {
+		st.mu.Unlock()
+		return
+	}
+	st.consumed = 0
+	st.mu.Unlock()
+	_ = st.s.write(st.id, kindWindow, binary.BigEndian.AppendUint32(nil, uint32(n)))
+}
+
+// grant handles a window update from the peer.
+func (st *Stream) grant(n int) {
+	st.mu.Lock()
+	st.credit += n
+	st.mu.Unlock()
+	select {
+	case st.credits <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,350 @@
+package mux
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vfor4/gonet/tlv"
+)
+
+// pair returns a client session connected to a server session serving h.
+func pair(t *testing.T, cfg Config, h Handler) (*Session, *Session) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := l.Accept()
+		accepted <- conn
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := <-accepted
+	if server == nil {
+		t.Fatal("accept failed")
+	}
+	c, s := NewClient(conn, cfg), NewServer(server, cfg, h)
+	t.Cleanup(func() {
+		_ = c.Close()
+		_ = s.Close()
+	})
+	return c, s
+}
+
+var upper = HandlerFunc(func(_ context.Context, req tlv.Payload) (tlv.Payload, error) {
+	if req.String() == "fail" {
+		return nil, errors.New("refusing to fail")
+	}
+	resp := tlv.String(strings.ToUpper(req.String()))
+	return &resp, nil
+})
+
+func TestCall(t *testing.T) {
+	c, _ := pair(t, Config{}, upper)
+
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := fmt.Sprintf("call %d", i)
+			resp, err := c.Call(context.Background(), tlv.String(req))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if expected := strings.ToUpper(req); resp.String() != expected {
+				t.Errorf("expected %q; actual %q", expected, resp)
+			}
+		}()
+	}
+	wg.Wait()
+
+	_, err := c.Call(context.Background(), tlv.String("fail"))
+	var reset *ResetError
+	if !errors.As(err, &reset) || reset.Message != "refusing to fail" {
+		t.Errorf("expected the handler error as a ResetError; actual %v", err)
+	}
+}
+
+func TestCallCancel(t *testing.T) {
+	canceled := make(chan error, 1)
+	c, _ := pair(t, Config{}, HandlerFunc(func(ctx context.Context, _ tlv.Payload) (tlv.Payload, error) {
+		<-ctx.Done()
+		canceled <- ctx.Err()
+		return nil, ctx.Err()
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := c.Call(ctx, tlv.String("wait")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded; actual %v", err)
+	}
+	select {
+	case err := <-canceled:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected the handler context to be canceled; actual %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the handler context was not canceled")
+	}
+}
+
+// TestFlowControl checks that a stream whose receiver stopped reading blocks
+// only its own sender.
+func TestFlowControl(t *testing.T) {
+	chunk := tlv.Binary(make([]byte, 16<<10))
+	// The sender may start a frame as long as it has any credit left.
+	frames := (MinWindow + len(chunk) + 4) / (len(chunk) + 5)
+	blocked := make(chan error, 1)
+	c, _ := pair(t, Config{Window: MinWindow}, handlerFunc(func(ctx context.Context, st *Stream) {
+		if _, err := st.Recv(ctx); err != nil {
+			return
+		}
+		if req, _ := st.Recv(ctx); req != nil {
+			// The fast stream sends a second ping where the slow one
+			// closes its side.
+			_ = st.Send(ctx, tlv.String("pong"))
+			return
+		}
+		for i := 0; ; i++ {
+			sendCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+			err := st.Send(sendCtx, chunk)
+			cancel()
+			if err != nil {
+				if i != frames {
+					err = fmt.Errorf("blocked after %d frames, not %d: %w", i, frames, err)
+				}
+				blocked <- err
+				return
+			}
+		}
+	}))
+
+	slow, err := c.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = slow.Send(context.Background(), tlv.String("flood me"))
+	_ = slow.CloseSend()
+
+	if err := <-blocked; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the sender to block; actual %v", err)
+	}
+
+	// Other streams are unaffected by the stalled one.
+	fast, err := c.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = fast.Send(context.Background(), tlv.String("ping"))
+	_ = fast.Send(context.Background(), tlv.String("ping"))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if p, err := fast.Recv(ctx); err != nil || p.String() != "pong" {
+		t.Errorf("expected pong; actual %v, %v", p, err)
+	}
+
+	// Reading the stalled stream drains its window.
+	for range frames {
+		if _, err := slow.Recv(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestWindowMismatch checks that peers with different windows respect the
+// smaller one instead of resetting each other's streams.
+func TestWindowMismatch(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	echo := handlerFunc(func(ctx context.Context, st *Stream) {
+		for {
+			p, err := st.Recv(ctx)
+			if err != nil {
+				return
+			}
+			if err := st.Send(ctx, p); err != nil {
+				return
+			}
+		}
+	})
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		s := NewServer(conn, Config{Window: MinWindow}, echo)
+		<-s.Done()
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewClient(conn, Config{Window: 4 * DefaultWindow})
+	defer c.Close()
+
+	st, err := c.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = 64
+	chunk := tlv.Binary(make([]byte, 8<<10))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	sent := make(chan error, 1)
+	go func() {
+		for range n {
+			if err := st.Send(ctx, chunk); err != nil {
+				sent <- err
+				return
+			}
+		}
+		sent <- st.CloseSend()
+	}()
+	for i := range n {
+		if _, err := st.Recv(ctx); err != nil {
+			t.Fatalf("payload %d: %v", i, err)
+		}
+	}
+	if err := <-sent; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestDataAfterClose checks that a peer sending on a stream it closed gets
+// the stream reset rather than bringing the session down.
+func TestDataAfterClose(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	served := make(chan *Session, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		served <- NewServer(conn, Config{}, handlerFunc(func(ctx context.Context, _ *Stream) {
+			<-ctx.Done()
+		}))
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := tlv.NewRegistry()
+	_ = reg.Register(FrameType, func() tlv.Payload { return &frame{reg: tlv.DefaultRegistry} })
+	raw := tlv.NewFramedConn(conn, tlv.Config{Registry: reg})
+	defer raw.Close()
+	s := <-served
+	defer s.Close()
+
+	var data bytes.Buffer
+	_, _ = tlv.String("x").WriteTo(&data)
+	for _, kind := range []uint8{kindData, kindClose, kindData} {
+		if err := raw.WritePayload(&frame{stream: 1, kind: kind, data: data.Bytes()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = raw.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		p, err := raw.ReadPayload()
+		if err != nil {
+			t.Fatalf("expected the stream to be reset; actual %v", err)
+		}
+		if f := p.(*frame); f.kind == kindReset {
+			if f.stream != 1 || !strings.Contains(string(f.data), ErrProtocol.Error()) {
+				t.Errorf("expected a protocol violation on stream 1; actual %v: %s", f, f.data)
+			}
+			break
+		}
+	}
+	if err := s.Err(); err != nil {
+		t.Errorf("expected the session to survive; actual %v", err)
+	}
+}
+
+func TestMaxStreams(t *testing.T) {
+	c, _ := pair(t, Config{MaxStreams: 2}, handlerFunc(func(ctx context.Context, st *Stream) {
+		if p, err := st.Recv(ctx); err == nil {
+			_ = st.Send(ctx, p)
+		}
+		<-ctx.Done()
+	}))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	open := func() (*Stream, error) {
+		st, err := c.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := st.Send(ctx, tlv.String("hi")); err != nil {
+			t.Fatal(err)
+		}
+		_, err = st.Recv(ctx)
+		return st, err
+	}
+
+	first, err := open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := open(); err != nil {
+		t.Fatal(err)
+	}
+	var reset *ResetError
+	if _, err := open(); !errors.As(err, &reset) || reset.Message != ErrTooManyStreams.Error() {
+		t.Fatalf("expected the third stream to be refused; actual %v", err)
+	}
+	// Ending a stream makes room for another.
+	first.Reset(nil)
+	if _, err := open(); err != nil {
+		t.Errorf("expected a stream to be accepted again; actual %v", err)
+	}
+}
+
+type handlerFunc func(ctx context.Context, st *Stream)
+
+func (f handlerFunc) ServeStream(ctx context.Context, st *Stream) { f(ctx, st) }
+
+func TestSessionClose(t *testing.T) {
+	c, s := pair(t, Config{}, handlerFunc(func(ctx context.Context, st *Stream) {
+		<-ctx.Done()
+	}))
+	st, err := c.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = st.Send(context.Background(), tlv.String("hang"))
+
+	_ = s.Close()
+	select {
+	case <-c.Done():
+	case <-time.After(time.Second):
+		t.Fatal("the client session did not notice the server closing")
+	}
+	if _, err := st.Recv(context.Background()); err == nil || errors.Is(err, io.EOF) {
+		t.Errorf("expected the stream to fail with the session; actual %v", err)
+	}
+	if _, err := c.Call(context.Background(), tlv.String("x")); err == nil {
+		t.Error("expected calls on an ended session to fail")
+	}
+}
@@ -0,0 +1,120 @@
+// Package tlv implements the type-length-value payload protocol spoken by
+// our services. A frame is a type byte, a big-endian uint32 body length and
+// the body itself.
+package tlv
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	BinaryType uint8 = iota + 1
+	StringType
+
+	// ReservedType is the first of the type IDs set aside for the protocol
+	// extensions of this module, such as stream multiplexing. Applications
+	// register their payloads below it.
+	ReservedType uint8 = 0xF0
+
+	// MaxPayloadSize is the largest body a frame may carry.
+	MaxPayloadSize uint32 = 10 << 20
+)
+
+// ErrMaxPayLoadSize is returned when a frame body exceeds the allowed size.
+var ErrMaxPayLoadSize = errors.New("maximum payload size exceeded")
+
+// UnknownTypeError is returned by Decode for a type byte that has no payload
+// registered. The body of the frame is left unread.
+type UnknownTypeError struct {
+	Type uint8
+}
+
+func (e *UnknownTypeError) Error() string {
+	return fmt.Sprintf("unknown payload type %d", e.Type)
+}
+
+// Payload is the body of a frame. WriteTo writes the whole frame, while
+// ReadFrom reads the length and the body that follow the type byte, which
+// Decode has already consumed to pick the payload.
+type Payload interface {
+	fmt.Stringer
+	io.ReaderFrom
+	io.WriterTo
+	Bytes() []byte
+}
+
+// Binary is a payload of raw bytes.
+type Binary []byte
+
+func (b Binary) String() string { return string(b) }
+
+func (b Binary) Bytes() []byte { return b }
+
+func (b Binary) WriteTo(w io.Writer) (int64, error) {
+	return WriteFrame(w, BinaryType, b)
+}
+
+func (b *Binary) ReadFrom(r io.Reader) (int64, error) {
+	body, n, err := ReadBody(r)
+	if err != nil {
+		return n, err
+	}
+	*b = body
+	return n, nil
+}
+
+// String is a payload of text.
+type String string
+
+func (s String) String() string { return string(s) }
+
+func (s String) Bytes() []byte { return []byte(s) }
+
+func (s String) WriteTo(w io.Writer) (int64, error) {
+	return WriteFrame(w, StringType, []byte(s))
+}
+
+func (s *String) ReadFrom(r io.Reader) (int64, error) {
+	body, n, err := ReadBody(r)
+	if err != nil {
+		return n, err
+	}
+	*s = String(body)
+	return n, nil
+}
+
+// WriteFrame writes a whole frame in a single Write so that concurrent
+// writers on a conn cannot interleave a header with another frame's body.
+// Payload implementations call it from WriteTo.
+func WriteFrame(w io.Writer, typ uint8, body []byte) (int64, error) {
+	if uint64(len(body)) > uint64(MaxPayloadSize) {
+		return 0, fmt.Errorf("%w: %d bytes", ErrMaxPayLoadSize, len(body))
+	}
+	b := make([]byte, 5+len(body))
+	b[0] = typ
+	binary.BigEndian.PutUint32(b[1:5], uint32(len(body)))
+	copy(b[5:], body)
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
+// ReadBody reads a length-prefixed body and returns it with the number of
+// bytes consumed, length included. Payload implementations call it from
+// ReadFrom.
+func ReadBody(r io.Reader) ([]byte, int64, error) {
+	var l [4]byte
+	n, err := io.ReadFull(r, l[:])
+	if err != nil {
+		return nil, int64(n), err
+	}
+	size := binary.BigEndian.Uint32(l[:])
+	if size > MaxPayloadSize {
+		return nil, int64(n), fmt.Errorf("%w: %d bytes", ErrMaxPayLoadSize, size)
+	}
+	body := make([]byte, size)
+	m, err := io.ReadFull(r, body)
+	return body, int64(n + m), err
+}
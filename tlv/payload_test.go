@@ -0,0 +1,74 @@
+package tlv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func TestDecode(t *testing.T) {
+	ping, empty, bin := String("ping"), String(""), Binary{0, 1, 2, 255}
+	payloads := []Payload{&ping, &bin, &empty}
+	buf := new(bytes.Buffer)
+	for _, p := range payloads {
+		n, err := p.WriteTo(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := int64(5 + len(p.Bytes())); n != expected {
+			t.Errorf("%q: expected %d bytes written; actual %d", p, expected, n)
+		}
+	}
+
+	// Short reads must not break decoding.
+	r := iotest.OneByteReader(buf)
+	for _, expected := range payloads {
+		actual, err := Decode(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual.String() != expected.String() {
+			t.Errorf("expected %q; actual %q", expected, actual)
+		}
+	}
+	if _, err := Decode(r); err != io.EOF {
+		t.Errorf("expected io.EOF; actual %v", err)
+	}
+}
+
+func TestReadFromCount(t *testing.T) {
+	buf := new(bytes.Buffer)
+	_, _ = String("hello").WriteTo(buf)
+	buf.Next(1) // the type byte
+	var s String
+	n, err := s.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 9 || s != "hello" {
+		t.Errorf("expected 9 bytes and %q; actual %d and %q", "hello", n, s)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	var unknown *UnknownTypeError
+	_, err := Decode(bytes.NewReader([]byte{42, 0, 0, 0, 0}))
+	if !errors.As(err, &unknown) || unknown.Type != 42 {
+		t.Errorf("expected an UnknownTypeError for type 42; actual %v", err)
+	}
+
+	huge := make([]byte, 5)
+	huge[0] = BinaryType
+	binary.BigEndian.PutUint32(huge[1:], MaxPayloadSize+1)
+	if _, err := Decode(bytes.NewReader(huge)); !errors.Is(err, ErrMaxPayLoadSize) {
+		t.Errorf("expected ErrMaxPayLoadSize; actual %v", err)
+	}
+
+	truncated := []byte{StringType, 0, 0, 0, 4, 'p', 'i'}
+	if _, err := Decode(bytes.NewReader(truncated)); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF; actual %v", err)
+	}
+}
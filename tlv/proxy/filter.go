@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"log"
+
+	"github.com/vfor4/gonet/tlv"
+)
+
+// Filter inspects a payload on its way through the proxy and returns the
+// payloads to forward in its place: none to drop it, itself to pass it on,
+// another to rewrite it, or several to inject payloads around it. The
+// payloads returned go through the next filter of the chain.
+type Filter interface {
+	Filter(dir Direction, p tlv.Payload) ([]tlv.Payload, error)
+}
+
+// FilterFunc is a function used as a Filter.
+type FilterFunc func(dir Direction, p tlv.Payload) ([]tlv.Payload, error)
+
+func (f FilterFunc) Filter(dir Direction, p tlv.Payload) ([]tlv.Payload, error) {
+	return f(dir, p)
+}
+
+// Match selects the payloads a filter acts on.
+type Match func(dir Direction, p tlv.Payload) bool
+
+// Log logs every payload with its direction to l, or to the standard logger
+// if nil, and passes it on.
+func Log(l *log.Logger) Filter {
+	if l == nil {
+		l = log.Default()
+	}
+	return FilterFunc(func(dir Direction, p tlv.Payload) ([]tlv.Payload, error) {
+		l.Printf("%v %T %v", dir, p, p)
+		return []tlv.Payload{p}, nil
+	})
+}
+
+// Drop discards the payloads that match.
+func Drop(match Match) Filter {
+	return FilterFunc(func(dir Direction, p tlv.Payload) ([]tlv.Payload, error) {
+		if match(dir, p) {
+			return nil, nil
+		}
+		return []tlv.Payload{p}, nil
+	})
+}
+
+// Rewrite replaces every payload with the one fn returns for it, or drops it
+// if fn returns nil.
+func Rewrite(fn func(dir Direction, p tlv.Payload) tlv.Payload) Filter {
+	return FilterFunc(func(dir Direction, p tlv.Payload) ([]tlv.Payload, error) {
+		if out := fn(dir, p); out != nil {
+			return []tlv.Payload{out}, nil
+		}
+		return nil, nil
+	})
+}
+
+// Inject sends payloads ahead of every payload that matches.
+func Inject(match Match, payloads ...tlv.Payload) Filter {
+	return FilterFunc(func(dir Direction, p tlv.Payload) ([]tlv.Payload, error) {
+		if !match(dir, p) {
+			return []tlv.Payload{p}, nil
+		}
+		return append(append([]tlv.Payload(nil), payloads...), p), nil
+	})
+}
+
+// run passes p through filters in order.
+func run(filters []Filter, dir Direction, p tlv.Payload) ([]tlv.Payload, error) {
+	ps := []tlv.Payload{p}
+	for _, f := range filters {
+		var next []tlv.Payload
+		for _, p := range ps {
+			out, err := f.Filter(dir, p)
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, out...)
+		}
+		ps = next
+	}
+	return ps, nil
+}
@@ -0,0 +1,218 @@
+// Package proxy relays TLV connections between clients and an upstream
+// server. Every frame is decoded, run through a chain of filters that may
+// log, drop, rewrite or inject payloads, and encoded again on the other side,
+// so protocol traffic between services can be debugged and gated.
+//
+// Each side of the proxy is a tlv.FramedConn of its own: compression and
+// STARTTLS are negotiated per hop, while the payloads the registry does not
+// know are relayed untouched as Opaque.
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+
+	"github.com/vfor4/gonet/tlv"
+)
+
+// Direction is the way a payload travels through the proxy.
+type Direction uint8
+
+const (
+	// Upstream is from the client to the server.
+	Upstream Direction = iota + 1
+	// Downstream is from the server to the client.
+	Downstream
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Upstream:
+		return "upstream"
+	case Downstream:
+		return "downstream"
+	default:
+		return fmt.Sprintf("direction(%d)", uint8(d))
+	}
+}
+
+// Opaque is a payload of a type the registry of the proxy does not know. It
+// is relayed as it was read.
+type Opaque struct {
+	Type uint8
+	Body []byte
+}
+
+func (o *Opaque) String() string {
+	return fmt.Sprintf("payload of type %d and %d bytes", o.Type, len(o.Body))
+}
+
+func (o *Opaque) Bytes() []byte { return o.Body }
+
+func (o *Opaque) WriteTo(w io.Writer) (int64, error) {
+	return tlv.WriteFrame(w, o.Type, o.Body)
+}
+
+func (o *Opaque) ReadFrom(r io.Reader) (int64, error) {
+	body, n, err := tlv.ReadBody(r)
+	if err != nil {
+		return n, err
+	}
+	o.Body = body
+	return n, nil
+}
+
+// Proxy relays the connections of clients to an upstream server through
+// Filters.
+type Proxy struct {
+	// Dial connects to the upstream server for each client.
+	Dial func(ctx context.Context) (net.Conn, error)
+	// Filters run in order on every payload, in both directions.
+	Filters []Filter
+	// Client configures the framing of the connections with clients. Its
+	// Registry decides which payloads the filters see decoded.
+	Client tlv.Config
+	// Server configures the framing of the connections to the server.
+	Server tlv.Config
+	// ServerTLS, if set, upgrades the connections to the server with
+	// StartTLS before anything is relayed.
+	ServerTLS *tls.Config
+	// ErrorLog logs the connections that end with an error. The standard
+	// logger if nil.
+	ErrorLog *log.Logger
+}
+
+// Serve relays the connections accepted on l until ctx is done, when it
+// closes l, or l fails. It returns once every connection ended.
+func (p *Proxy) Serve(ctx context.Context, l net.Listener) error {
+	stop := context.AfterFunc(ctx, func() { _ = l.Close() })
+	defer stop()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := p.ServeConn(ctx, conn)
+			if err != nil && (ctx.Err() == nil || !errors.Is(err, ctx.Err())) {
+				p.logf("proxy %v: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// ServeConn dials the server and relays conn to it until either side closes
+// its connection, a filter fails or ctx is done. A side that stops sending
+// is half-closed on the other when its connection supports it, so that the
+// replies still in flight get through. ServeConn closes conn.
+func (p *Proxy) ServeConn(ctx context.Context, conn net.Conn) error {
+	client := tlv.NewFramedConn(conn, relayConfig(p.Client))
+	defer client.Close()
+	upstream, err := p.Dial(ctx)
+	if err != nil {
+		return fmt.Errorf("dial upstream: %w", err)
+	}
+	server := tlv.NewFramedConn(upstream, relayConfig(p.Server))
+	defer server.Close()
+	if p.ServerTLS != nil {
+		if err := server.StartTLS(ctx, p.ServerTLS); err != nil {
+			return fmt.Errorf("upgrade upstream: %w", err)
+		}
+	}
+
+	closeBoth := func() {
+		_ = client.Close()
+		_ = server.Close()
+	}
+	stop := context.AfterFunc(ctx, closeBoth)
+	defer stop()
+	errs := make(chan error, 2)
+	go func() { errs <- p.relay(Upstream, client, server) }()
+	go func() { errs <- p.relay(Downstream, server, client) }()
+	var first error
+	for range 2 {
+		// Only the proxy closes the connections, so net.ErrClosed is the
+		// other direction giving up, not a failure of its own.
+		if err := <-errs; err != nil && !errors.Is(err, net.ErrClosed) && first == nil {
+			first = err
+			closeBoth()
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return first
+}
+
+// relay forwards the payloads read from src to dst until src ends.
+func (p *Proxy) relay(dir Direction, src, dst *tlv.FramedConn) error {
+	for {
+		in, err := src.ReadPayload()
+		if errors.Is(err, io.EOF) {
+			return closeWrite(dst)
+		}
+		if err != nil {
+			return fmt.Errorf("%v read: %w", dir, err)
+		}
+		out, err := run(p.Filters, dir, in)
+		if err != nil {
+			return fmt.Errorf("%v filter: %w", dir, err)
+		}
+		for _, payload := range out {
+			if err := dst.WritePayload(payload); err != nil {
+				return fmt.Errorf("%v write: %w", dir, err)
+			}
+		}
+	}
+}
+
+// closeWrite half-closes c, or closes it if its connection cannot.
+func closeWrite(c *tlv.FramedConn) error {
+	if cw, ok := c.NetConn().(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Close()
+}
+
+func (p *Proxy) logf(format string, args ...any) {
+	if p.ErrorLog != nil {
+		p.ErrorLog.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// relayConfig returns cfg with a registry that decodes every type the
+// registry of cfg does not know as Opaque.
+func relayConfig(cfg tlv.Config) tlv.Config {
+	reg := cfg.Registry
+	if reg == nil {
+		reg = tlv.DefaultRegistry
+	}
+	reg = reg.Clone()
+	for t := range 256 {
+		switch typ := uint8(t); typ {
+		case tlv.HelloType, tlv.StartTLSType:
+			// These belong to a single hop; relaying them would negotiate
+			// compression or TLS with the wrong peer.
+		default:
+			// Taken types and Magic are refused, which is what we want.
+			_ = reg.Register(typ, func() tlv.Payload { return &Opaque{Type: typ} })
+		}
+	}
+	cfg.Registry = reg
+	return cfg
+}
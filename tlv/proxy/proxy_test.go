@@ -0,0 +1,262 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/vfor4/gonet/tlv"
+)
+
+// echoServer returns the address of a server that sends every payload back
+// and closes its end once the client stopped sending.
+func echoServer(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				c := tlv.NewFramedConn(conn, relayConfig(tlv.Config{}))
+				defer c.Close()
+				for {
+					p, err := c.ReadPayload()
+					if err != nil {
+						return
+					}
+					if err := c.WritePayload(p); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// serve runs p on a new listener in front of an echo server until the test
+// ends, and returns the address to dial and a func that stops p early.
+func serve(t *testing.T, p *Proxy) (string, func()) {
+	t.Helper()
+	upstream := echoServer(t)
+	p.Dial = func(ctx context.Context) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", upstream)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = p.Serve(ctx, l)
+	}()
+	stop := sync.OnceFunc(func() {
+		cancel()
+		<-done
+	})
+	t.Cleanup(stop)
+	return l.Addr().String(), stop
+}
+
+func dial(t *testing.T, addr string) *tlv.FramedConn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := tlv.NewFramedConn(conn, relayConfig(tlv.Config{}))
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+func str(s string) *tlv.String {
+	p := tlv.String(s)
+	return &p
+}
+
+func TestRelay(t *testing.T) {
+	var logs bytes.Buffer
+	var mu sync.Mutex
+	l := log.New(&syncWriter{mu: &mu, w: &logs}, "", 0)
+	addr, _ := serve(t, &Proxy{Filters: []Filter{Log(l)}})
+	c := dial(t, addr)
+
+	sent := []tlv.Payload{str("ping"), &Opaque{Type: 99, Body: []byte{1, 2, 3}}}
+	for _, p := range sent {
+		if err := c.WritePayload(p); err != nil {
+			t.Fatal(err)
+		}
+		got, err := c.ReadPayload()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.String() != p.String() {
+			t.Errorf("expected %v back; actual %v", p, got)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, want := range []string{
+		"upstream *tlv.String ping",
+		"downstream *tlv.String ping",
+		"upstream *proxy.Opaque payload of type 99 and 3 bytes",
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("expected the log to contain %q; actual %q", want, logs.String())
+		}
+	}
+}
+
+func TestFilters(t *testing.T) {
+	upstream := func(dir Direction, _ tlv.Payload) bool { return dir == Upstream }
+	secret := func(_ Direction, p tlv.Payload) bool { return p.String() == "secret" }
+	addr, _ := serve(t, &Proxy{Filters: []Filter{
+		Drop(secret),
+		Rewrite(func(dir Direction, p tlv.Payload) tlv.Payload {
+			if dir != Upstream {
+				return p
+			}
+			return str(strings.ToUpper(p.String()))
+		}),
+		Inject(upstream, str("via proxy")),
+	}})
+	c := dial(t, addr)
+
+	for _, s := range []string{"secret", "ping"} {
+		if err := c.WritePayload(str(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, want := range []string{"via proxy", "PING"} {
+		got, err := c.ReadPayload()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.String() != want {
+			t.Errorf("expected %q; actual %q", want, got)
+		}
+	}
+}
+
+func TestRewriteDrop(t *testing.T) {
+	addr, _ := serve(t, &Proxy{Filters: []Filter{
+		Rewrite(func(_ Direction, p tlv.Payload) tlv.Payload {
+			if p.String() == "secret" {
+				return nil
+			}
+			return p
+		}),
+	}})
+	c := dial(t, addr)
+
+	for _, s := range []string{"secret", "ping"} {
+		if err := c.WritePayload(str(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, err := c.ReadPayload(); err != nil || got.String() != "ping" {
+		t.Errorf("expected %q alone; actual %v, %v", "ping", got, err)
+	}
+}
+
+func TestHalfClose(t *testing.T) {
+	addr, _ := serve(t, &Proxy{})
+	c := dial(t, addr)
+	if err := c.WritePayload(str("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.NetConn().(*net.TCPConn).CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := c.ReadPayload(); err != nil || got.String() != "ping" {
+		t.Fatalf("expected the reply in flight; actual %v, %v", got, err)
+	}
+	if _, err := c.ReadPayload(); !errors.Is(err, io.EOF) {
+		t.Errorf("expected io.EOF once the server is done; actual %v", err)
+	}
+}
+
+func TestFilterError(t *testing.T) {
+	var logs bytes.Buffer
+	var mu sync.Mutex
+	boom := FilterFunc(func(_ Direction, p tlv.Payload) ([]tlv.Payload, error) {
+		if p.String() == "boom" {
+			return nil, errors.New("refusing boom")
+		}
+		return []tlv.Payload{p}, nil
+	})
+	p := &Proxy{Filters: []Filter{boom}, ErrorLog: log.New(&syncWriter{mu: &mu, w: &logs}, "", 0)}
+	addr, stop := serve(t, p)
+	c := dial(t, addr)
+
+	if err := c.WritePayload(str("boom")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ReadPayload(); err == nil {
+		t.Fatal("expected the proxy to drop the connection")
+	}
+	stop()
+	mu.Lock()
+	defer mu.Unlock()
+	if !strings.Contains(logs.String(), "upstream filter: refusing boom") {
+		t.Errorf("expected the failure to be logged; actual %q", logs.String())
+	}
+}
+
+func TestServeCanceled(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream := echoServer(t)
+	p := &Proxy{Dial: func(ctx context.Context) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", upstream)
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- p.Serve(ctx, l) }()
+
+	c := dial(t, l.Addr().String())
+	if err := c.WritePayload(str("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ReadPayload(); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if err := <-served; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled; actual %v", err)
+	}
+	if _, err := c.ReadPayload(); err == nil {
+		t.Error("expected the relayed connection to be closed")
+	}
+}
+
+type syncWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(b)
+}
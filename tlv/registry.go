@@ -0,0 +1,89 @@
+package tlv
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"sync"
+)
+
+// ErrDuplicateType is returned when a type ID is registered twice.
+var ErrDuplicateType = errors.New("payload type already registered")
+
+// Registry maps type IDs to the payloads Decode creates for them. It is safe
+// for concurrent use.
+type Registry struct {
+	mu    sync.RWMutex
+	types map[uint8]func() Payload
+}
+
+// NewRegistry returns a registry that knows the payloads of this package:
+// Binary, String, Chunk, Hello, StartTLS and Auth.
+func NewRegistry() *Registry {
+	return &Registry{types: map[uint8]func() Payload{
+		BinaryType:   func() Payload { return new(Binary) },
+		StringType:   func() Payload { return new(String) },
+		ChunkType:    func() Payload { return new(Chunk) },
+		HelloType:    func() Payload { return new(Hello) },
+		StartTLSType: func() Payload { return new(StartTLS) },
+		AuthType:     func() Payload { return new(Auth) },
+	}}
+}
+
+// DefaultRegistry is the registry used by Decode and Register.
+var DefaultRegistry = NewRegistry()
+
+// Register makes Decode return a payload created by newPayload for frames of
+// type t. It returns an error wrapping ErrDuplicateType if t is taken, and an
+// error for Magic, which no frame type may use.
+func (r *Registry) Register(t uint8, newPayload func() Payload) error {
+	if t == Magic {
+		return fmt.Errorf("type %d is the frame header magic", t)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.types[t]; ok {
+		return fmt.Errorf("%w: %d", ErrDuplicateType, t)
+	}
+	r.types[t] = newPayload
+	return nil
+}
+
+// Clone returns a copy of r, so that a connection can register payloads of
+// its own on top of a shared registry.
+func (r *Registry) Clone() *Registry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return &Registry{types: maps.Clone(r.types)}
+}
+
+// Decode reads the next frame from rd. It returns an *UnknownTypeError for a
+// type that is not registered.
+func (r *Registry) Decode(rd io.Reader) (Payload, error) {
+	var t [1]byte
+	if _, err := io.ReadFull(rd, t[:]); err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	newPayload, ok := r.types[t[0]]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, &UnknownTypeError{Type: t[0]}
+	}
+	payload := newPayload()
+	if _, err := payload.ReadFrom(rd); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// Register registers a payload in DefaultRegistry.
+func Register(t uint8, newPayload func() Payload) error {
+	return DefaultRegistry.Register(t, newPayload)
+}
+
+// Decode reads the next frame from r using DefaultRegistry.
+func Decode(r io.Reader) (Payload, error) {
+	return DefaultRegistry.Decode(r)
+}
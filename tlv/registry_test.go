@@ -0,0 +1,99 @@
+package tlv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"testing"
+)
+
+const (
+	uint64Type uint8 = 100 + iota
+	pointType
+)
+
+// Uint64 is a payload carrying a single integer.
+type Uint64 uint64
+
+func (u Uint64) String() string { return strconv.FormatUint(uint64(u), 10) }
+
+func (u Uint64) Bytes() []byte { return binary.BigEndian.AppendUint64(nil, uint64(u)) }
+
+func (u Uint64) WriteTo(w io.Writer) (int64, error) { return WriteFrame(w, uint64Type, u.Bytes()) }
+
+func (u *Uint64) ReadFrom(r io.Reader) (int64, error) {
+	body, n, err := ReadBody(r)
+	if err != nil {
+		return n, err
+	}
+	if len(body) != 8 {
+		return n, fmt.Errorf("integer payload of %d bytes", len(body))
+	}
+	*u = Uint64(binary.BigEndian.Uint64(body))
+	return n, nil
+}
+
+// point is a payload encoded as JSON.
+type point struct {
+	X, Y int
+}
+
+func (p *point) String() string { return fmt.Sprintf("(%d,%d)", p.X, p.Y) }
+
+func (p *point) Bytes() []byte {
+	b, _ := json.Marshal(p)
+	return b
+}
+
+func (p *point) WriteTo(w io.Writer) (int64, error) { return WriteFrame(w, pointType, p.Bytes()) }
+
+func (p *point) ReadFrom(r io.Reader) (int64, error) {
+	body, n, err := ReadBody(r)
+	if err != nil {
+		return n, err
+	}
+	return n, json.Unmarshal(body, p)
+}
+
+func TestRegistry(t *testing.T) {
+	reg := NewRegistry()
+	if err := reg.Register(uint64Type, func() Payload { return new(Uint64) }); err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.Register(uint64Type, func() Payload { return new(Uint64) }); !errors.Is(err, ErrDuplicateType) {
+		t.Errorf("expected ErrDuplicateType; actual %v", err)
+	}
+	if err := reg.Register(StringType, func() Payload { return new(Binary) }); !errors.Is(err, ErrDuplicateType) {
+		t.Errorf("expected the built-in String type to be taken; actual %v", err)
+	}
+
+	// A per-connection registry extends the shared one without changing it.
+	conn := reg.Clone()
+	if err := conn.Register(pointType, func() Payload { return new(point) }); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	_, _ = Uint64(1 << 40).WriteTo(buf)
+	_, _ = (&point{X: 1, Y: 2}).WriteTo(buf)
+	_, _ = String("hi").WriteTo(buf)
+	for _, expected := range []string{"1099511627776", "(1,2)", "hi"} {
+		p, err := conn.Decode(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.String() != expected {
+			t.Errorf("expected %q; actual %q", expected, p)
+		}
+	}
+
+	_, _ = (&point{}).WriteTo(buf)
+	var unknown *UnknownTypeError
+	if _, err := reg.Decode(buf); !errors.As(err, &unknown) || unknown.Type != pointType {
+		t.Errorf("expected the shared registry not to know type %d; actual %v", pointType, err)
+	}
+}
@@ -0,0 +1,189 @@
+package tlv
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// StartTLSType is the type of the StartTLS payload.
+const StartTLSType = ReservedType + 3
+
+// StartTLS statuses.
+const (
+	StartTLSRequest uint8 = iota
+	StartTLSReady
+	StartTLSRefused
+)
+
+var (
+	// ErrTLSRequired is returned by ReadPayload for an application payload
+	// received before the connection was upgraded, when Config.RequireTLS
+	// is set. The payload is discarded.
+	ErrTLSRequired = errors.New("payload received before STARTTLS")
+	// ErrTLSRefused is returned by StartTLS when the peer does not offer
+	// TLS.
+	ErrTLSRefused = errors.New("STARTTLS refused by peer")
+)
+
+// StartTLS upgrades a connection to TLS in band, like STARTTLS in SMTP: the
+// client sends a request, the server answers ready or refused, and on ready
+// both ends perform a TLS handshake on the same connection. StartTLS never
+// reaches the application.
+type StartTLS struct {
+	Status uint8
+}
+
+func (s *StartTLS) String() string {
+	switch s.Status {
+	case StartTLSRequest:
+		return "starttls request"
+	case StartTLSReady:
+		return "starttls ready"
+	case StartTLSRefused:
+		return "starttls refused"
+	default:
+		return fmt.Sprintf("starttls status %d", s.Status)
+	}
+}
+
+func (s *StartTLS) Bytes() []byte { return []byte{s.Status} }
+
+func (s *StartTLS) WriteTo(w io.Writer) (int64, error) {
+	return WriteFrame(w, StartTLSType, s.Bytes())
+}
+
+func (s *StartTLS) ReadFrom(r io.Reader) (int64, error) {
+	body, n, err := ReadBody(r)
+	if err != nil {
+		return n, err
+	}
+	if len(body) != 1 {
+		return n, fmt.Errorf("starttls payload of %d bytes", len(body))
+	}
+	s.Status = body[0]
+	return n, nil
+}
+
+// ServerTLSConfig returns the TLS settings of our servers, the same as
+// cmd/protobuf/server uses: TLS 1.2 or later over the P-256 curve.
+func ServerTLSConfig(certs ...tls.Certificate) *tls.Config {
+	return &tls.Config{
+		Certificates:     certs,
+		CurvePreferences: []tls.CurveID{tls.CurveP256},
+		MinVersion:       tls.VersionTLS12,
+	}
+}
+
+// ClientTLSConfig returns the client side of ServerTLSConfig, trusting
+// roots, or the system roots if nil.
+func ClientTLSConfig(roots *x509.CertPool) *tls.Config {
+	return &tls.Config{
+		RootCAs:          roots,
+		CurvePreferences: []tls.CurveID{tls.CurveP256},
+		MinVersion:       tls.VersionTLS12,
+	}
+}
+
+// StartTLS asks the peer to upgrade the connection and performs the client
+// side of the TLS handshake with cfg. It must be called before any other
+// payload is exchanged, and returns ErrTLSRefused if the peer does not have
+// TLS configured.
+func (c *FramedConn) StartTLS(ctx context.Context, cfg *tls.Config) error {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.rerr != nil {
+		return c.rerr
+	}
+	if c.werr != nil {
+		return c.werr
+	}
+	c.buf.Reset()
+	if err := c.appendFrame(&StartTLS{Status: StartTLSRequest}, 0); err != nil {
+		return err
+	}
+	if _, err := c.conn.Write(c.buf.Bytes()); err != nil {
+		return c.writeErr(err)
+	}
+	var p Payload
+	for {
+		var err error
+		p, err = c.readPayload()
+		if err != nil {
+			return err
+		}
+		if h, ok := p.(*Hello); ok {
+			c.hello(h)
+			continue
+		}
+		break
+	}
+	reply, ok := p.(*StartTLS)
+	switch {
+	case !ok:
+		return fmt.Errorf("unexpected %T payload before the STARTTLS reply", p)
+	case reply.Status == StartTLSRefused:
+		return ErrTLSRefused
+	case reply.Status != StartTLSReady:
+		return fmt.Errorf("unexpected STARTTLS reply %v", reply)
+	}
+	return c.upgrade(ctx, tls.Client(c.conn, cfg))
+}
+
+// serveStartTLS answers a StartTLS request read by ReadPayload. c.rmu must
+// be held.
+func (c *FramedConn) serveStartTLS(req *StartTLS) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	reply := &StartTLS{Status: StartTLSReady}
+	if req.Status != StartTLSRequest || c.cfg.TLS == nil || c.tls != nil {
+		reply.Status = StartTLSRefused
+	}
+	c.buf.Reset()
+	if err := c.appendFrame(reply, 0); err != nil {
+		return err
+	}
+	if _, err := c.conn.Write(c.buf.Bytes()); err != nil {
+		return c.readErr(c.writeErr(err), true)
+	}
+	if reply.Status == StartTLSRefused {
+		return nil
+	}
+	return c.upgrade(context.Background(), tls.Server(c.conn, c.cfg.TLS))
+}
+
+// upgrade performs the handshake and switches the connection over to TLS.
+// c.rmu and c.wmu must be held.
+func (c *FramedConn) upgrade(ctx context.Context, conn *tls.Conn) error {
+	// Anything that arrived along with the STARTTLS exchange was sent in
+	// the clear and could have been injected; it must not be read as if it
+	// came over TLS.
+	if c.r.Buffered() > 0 {
+		return c.readErr(errors.New("plaintext data received after STARTTLS"), true)
+	}
+	if err := conn.HandshakeContext(ctx); err != nil {
+		_ = c.Close()
+		return c.readErr(err, true)
+	}
+	c.cmu.Lock()
+	c.conn, c.r, c.tls = conn, bufio.NewReader(conn), conn
+	c.cmu.Unlock()
+	return nil
+}
+
+// ConnectionState returns the state of the TLS connection and true once the
+// connection was upgraded with StartTLS.
+func (c *FramedConn) ConnectionState() (tls.ConnectionState, bool) {
+	c.cmu.Lock()
+	defer c.cmu.Unlock()
+	if c.tls == nil {
+		return tls.ConnectionState{}, false
+	}
+	return c.tls.ConnectionState(), true
+}
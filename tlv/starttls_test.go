@@ -0,0 +1,128 @@
+package tlv
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// testCert returns a self-signed certificate for 127.0.0.1 and a pool that
+// trusts it.
+func testCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "tlv test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, roots
+}
+
+func TestStartTLS(t *testing.T) {
+	cert, roots := testCert(t)
+	c1, c2 := tcpPair(t)
+	client := NewFramedConn(c1, Config{})
+	server := NewFramedConn(c2, Config{TLS: ServerTLSConfig(cert), RequireTLS: true})
+
+	received := make(chan Payload, 1)
+	go func() {
+		p, err := server.ReadPayload()
+		if err != nil {
+			t.Error(err)
+		}
+		received <- p
+		_ = server.WritePayload(String("pong"))
+	}()
+
+	cfg := ClientTLSConfig(roots)
+	cfg.ServerName = "127.0.0.1"
+	if err := client.StartTLS(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	state, ok := client.ConnectionState()
+	if !ok || state.Version < tls.VersionTLS12 {
+		t.Fatalf("expected a TLS 1.2+ connection; actual %x, %t", state.Version, ok)
+	}
+	if _, ok := client.NetConn().(*tls.Conn); !ok {
+		t.Errorf("expected the frames to travel over TLS; actual %T", client.NetConn())
+	}
+	if err := client.WritePayload(String("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if p := <-received; p == nil || p.String() != "ping" {
+		t.Errorf("expected ping; actual %v", p)
+	}
+	if p, err := client.ReadPayload(); err != nil || p.String() != "pong" {
+		t.Errorf("expected pong; actual %v, %v", p, err)
+	}
+}
+
+func TestStartTLSRequired(t *testing.T) {
+	cert, _ := testCert(t)
+	c1, c2 := tcpPair(t)
+	client := NewFramedConn(c1, Config{})
+	server := NewFramedConn(c2, Config{TLS: ServerTLSConfig(cert), RequireTLS: true})
+
+	_ = client.WritePayload(String("in the clear"))
+	if _, err := server.ReadPayload(); !errors.Is(err, ErrTLSRequired) {
+		t.Errorf("expected ErrTLSRequired; actual %v", err)
+	}
+}
+
+func TestStartTLSRefused(t *testing.T) {
+	c1, c2 := tcpPair(t)
+	client := NewFramedConn(c1, Config{})
+	server := NewFramedConn(c2, Config{})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p, err := server.ReadPayload()
+		if err != nil || p.String() != "plain" {
+			t.Errorf("expected the session to go on in plaintext; actual %v, %v", p, err)
+		}
+	}()
+	if err := client.StartTLS(context.Background(), ClientTLSConfig(nil)); !errors.Is(err, ErrTLSRefused) {
+		t.Fatalf("expected ErrTLSRefused; actual %v", err)
+	}
+	if err := client.WritePayload(String("plain")); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+}
+
+func TestServerTLSConfig(t *testing.T) {
+	cfg := ServerTLSConfig()
+	if cfg.MinVersion != tls.VersionTLS12 || len(cfg.CurvePreferences) != 1 || cfg.CurvePreferences[0] != tls.CurveP256 {
+		t.Errorf("unexpected settings: min version %x, curves %v", cfg.MinVersion, cfg.CurvePreferences)
+	}
+}